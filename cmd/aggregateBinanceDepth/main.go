@@ -11,6 +11,7 @@ import (
 
 	"github.com/aggregate-binance-depth/internal/app"
 	"github.com/aggregate-binance-depth/internal/config"
+	"github.com/aggregate-binance-depth/internal/tracing"
 )
 
 const (
@@ -26,7 +27,14 @@ func main() {
 
 	log.Info("logger init successfull")
 
-	application, err := app.NewApp(log, config.Binance.Depth.Symbols)
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Options(config.Tracing))
+
+	if err != nil {
+		log.Error("main error", slog.String("error", err.Error()))
+		commomLogg.Fatal(err)
+	}
+
+	application, err := app.NewApp(log, config)
 
 	if err != nil {
 		log.Error("main error", slog.String("error", err.Error()))
@@ -34,7 +42,13 @@ func main() {
 	}
 
 	go application.DepthGateService.Serve()
-	go application.WsServer.Serve(config.Wss.Port)
+	go application.WsServer.Serve()
+	go application.GrpcServer.Serve()
+	go application.Bars.Serve()
+
+	if application.Snapshots != nil {
+		go application.Snapshots.Serve()
+	}
 
 	// Graceful shutdown
 	stop := make(chan os.Signal, 1)
@@ -49,8 +63,24 @@ func main() {
 	defer cancel()
 
 	application.WsServer.Shutdown(ctx)
+	application.GrpcServer.Shutdown(ctx)
 	application.DepthGateService.Shutdown()
-	application.Wss.Disconnect()
+	application.Bars.Shutdown()
+
+	if application.Snapshots != nil {
+		application.Snapshots.Shutdown()
+	}
+	for _, wss := range application.Wss {
+		wss.Disconnect()
+	}
+
+	if application.Recorder != nil {
+		application.Recorder.Close()
+	}
+
+	if err := shutdownTracing(ctx); err != nil {
+		log.Error("error with tracing shutdown", slog.String("error", err.Error()))
+	}
 
 	log.Info("application stoped.")
 }
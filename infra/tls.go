@@ -0,0 +1,168 @@
+package infra
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLSFiles are PEM files of the server certificate, ClientCAFile enables mutual TLS
+type TLSFiles struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+}
+
+// CertReloader serves the certificate and client CA bundle from disk and picks up
+// changed files without restarting the server
+type CertReloader struct {
+	log       *slog.Logger
+	files     TLSFiles
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  map[string]time.Time
+}
+
+func NewCertReloader(l *slog.Logger, files TLSFiles) (*CertReloader, error) {
+	const op = "infra.tls.NewCertReloader"
+
+	r := &CertReloader{log: l, files: files, modTimes: make(map[string]time.Time)}
+
+	if err := r.reload(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return r, nil
+}
+
+func (r *CertReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.files.CertFile, r.files.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	var clientCAs *x509.CertPool
+
+	if r.files.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.files.ClientCAFile)
+		if err != nil {
+			return err
+		}
+
+		clientCAs = x509.NewCertPool()
+
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates in %s", r.files.ClientCAFile)
+		}
+	}
+
+	modTimes, err := r.readModTimes()
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cert = &cert
+	r.clientCAs = clientCAs
+	r.modTimes = modTimes
+
+	return nil
+}
+
+func (r *CertReloader) readModTimes() (map[string]time.Time, error) {
+	res := make(map[string]time.Time, 3)
+
+	for _, path := range []string{r.files.CertFile, r.files.KeyFile, r.files.ClientCAFile} {
+		if path == "" {
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		res[path] = info.ModTime()
+	}
+
+	return res, nil
+}
+
+func (r *CertReloader) changed() bool {
+	modTimes, err := r.readModTimes()
+	if err != nil {
+		// a file being replaced can be missing for a moment, the next tick retries
+		return false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for path, modTime := range modTimes {
+		if !modTime.Equal(r.modTimes[path]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Watch polls the files every interval until ctx is done, a broken new pair keeps the old one in use
+func (r *CertReloader) Watch(ctx context.Context, interval time.Duration) {
+	const op = "infra.tls.Watch"
+
+	logger := r.log.With(slog.String("op", op))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+
+			if err := r.reload(); err != nil {
+				logger.Error("error with reload tls files", slog.String("error", err.Error()))
+
+				continue
+			}
+
+			logger.Info("tls files reloaded")
+		}
+	}
+}
+
+// TLSConfig resolves certificate and client CAs per handshake so reloads apply to new connections
+func (r *CertReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*r.cert},
+			}
+
+			if r.clientCAs != nil {
+				cfg.ClientCAs = r.clientCAs
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+
+			return cfg, nil
+		},
+	}
+}
@@ -0,0 +1,113 @@
+package infra
+
+import (
+	"net"
+	"sync/atomic"
+)
+
+// Compression configures permessage-deflate for a websocket side
+type Compression struct {
+	Enabled bool
+	// Level is a compress/flate level from -2 (huffman only) to 9 (best compression)
+	Level int
+}
+
+// TrafficStats compares payload bytes with the bytes that actually crossed the wire,
+// the difference shows what compression saves
+type TrafficStats struct {
+	rawRead     atomic.Uint64
+	wireRead    atomic.Uint64
+	rawWritten  atomic.Uint64
+	wireWritten atomic.Uint64
+}
+
+type TrafficSnapshot struct {
+	RawReadBytes     uint64  `json:"rawReadBytes"`
+	WireReadBytes    uint64  `json:"wireReadBytes"`
+	ReadRatio        float64 `json:"readRatio"`
+	RawWrittenBytes  uint64  `json:"rawWrittenBytes"`
+	WireWrittenBytes uint64  `json:"wireWrittenBytes"`
+	WriteRatio       float64 `json:"writeRatio"`
+}
+
+func (s *TrafficStats) AddRawRead(n int) {
+	s.rawRead.Add(uint64(n))
+}
+
+func (s *TrafficStats) AddRawWritten(n int) {
+	s.rawWritten.Add(uint64(n))
+}
+
+// Snapshot returns the counters, ratios are wire bytes per payload byte
+func (s *TrafficStats) Snapshot() TrafficSnapshot {
+	res := TrafficSnapshot{
+		RawReadBytes:     s.rawRead.Load(),
+		WireReadBytes:    s.wireRead.Load(),
+		RawWrittenBytes:  s.rawWritten.Load(),
+		WireWrittenBytes: s.wireWritten.Load(),
+	}
+
+	if res.RawReadBytes > 0 {
+		res.ReadRatio = float64(res.WireReadBytes) / float64(res.RawReadBytes)
+	}
+
+	if res.RawWrittenBytes > 0 {
+		res.WriteRatio = float64(res.WireWrittenBytes) / float64(res.RawWrittenBytes)
+	}
+
+	return res
+}
+
+// CountingConn adds wire bytes to stats once Track is called
+type CountingConn struct {
+	net.Conn
+	stats atomic.Pointer[TrafficStats]
+}
+
+func NewCountingConn(conn net.Conn, stats *TrafficStats) *CountingConn {
+	c := &CountingConn{Conn: conn}
+
+	c.Track(stats)
+
+	return c
+}
+
+func (c *CountingConn) Track(stats *TrafficStats) {
+	c.stats.Store(stats)
+}
+
+func (c *CountingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+
+	if stats := c.stats.Load(); stats != nil {
+		stats.wireRead.Add(uint64(n))
+	}
+
+	return n, err
+}
+
+func (c *CountingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+
+	if stats := c.stats.Load(); stats != nil {
+		stats.wireWritten.Add(uint64(n))
+	}
+
+	return n, err
+}
+
+// CountingListener wraps accepted connections in CountingConn without tracking,
+// handlers decide which connections are worth counting
+type CountingListener struct {
+	net.Listener
+}
+
+func (l CountingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewCountingConn(conn, nil), nil
+}
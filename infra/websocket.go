@@ -1,8 +1,11 @@
 package infra
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"log/slog"
+	"net"
 	"time"
 
 	"github.com/aggregate-binance-depth/services"
@@ -16,12 +19,32 @@ const (
 
 type WebsocketConnection struct {
 	*websocket.Conn
+	Compression Compression
+	// Stats is optional, when set it receives payload and wire byte counts
+	Stats *TrafficStats
+	// Keepalive pings the peer every interval, a read fails once no pong came for two intervals
+	// so a silently dead connection is noticed, zero disables it
+	Keepalive time.Duration
 }
 
 func (ws WebsocketConnection) Connect(url string) (services.WsConnection, error) {
 	dialer := websocket.Dialer{
 		HandshakeTimeout:  handshakeTimeout,
-		EnableCompression: false,
+		EnableCompression: ws.Compression.Enabled,
+	}
+
+	if ws.Stats != nil {
+		dialer.NetDialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			var d net.Dialer
+
+			conn, err := d.DialContext(ctx, network, addr)
+
+			if err != nil {
+				return nil, err
+			}
+
+			return NewCountingConn(conn, ws.Stats), nil
+		}
 	}
 
 	conn, _, err := dialer.Dial(url, nil)
@@ -30,7 +53,59 @@ func (ws WebsocketConnection) Connect(url string) (services.WsConnection, error)
 		return nil, err
 	}
 
-	return &WebsocketConnection{Conn: conn}, nil
+	if ws.Compression.Enabled {
+		if err := conn.SetCompressionLevel(ws.Compression.Level); err != nil {
+			conn.Close()
+
+			return nil, err
+		}
+	}
+
+	if ws.Keepalive > 0 {
+		keepalive(conn, ws.Keepalive)
+	}
+
+	return &WebsocketConnection{Conn: conn, Compression: ws.Compression, Stats: ws.Stats, Keepalive: ws.Keepalive}, nil
+}
+
+// keepalive pings conn every interval until a ping fails, every pong extends the read deadline
+func keepalive(conn *websocket.Conn, interval time.Duration) {
+	conn.SetReadDeadline(time.Now().Add(2 * interval))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * interval))
+	})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			// WriteControl may run next to the reader and writers, it fails once conn is closed
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(interval)); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+func (ws *WebsocketConnection) ReadMessage() (int, []byte, error) {
+	t, p, err := ws.Conn.ReadMessage()
+
+	if ws.Stats != nil {
+		ws.Stats.AddRawRead(len(p))
+	}
+
+	return t, p, err
+}
+
+func (ws *WebsocketConnection) ReadJSON(v interface{}) error {
+	_, p, err := ws.ReadMessage()
+
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(p, v)
 }
 
 func (ws *WebsocketConnection) Disconnect(l *slog.Logger) error {
@@ -0,0 +1,76 @@
+package adapters
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	internalServices "github.com/aggregate-binance-depth/internal/services"
+	"github.com/aggregate-binance-depth/services/binance"
+)
+
+// convertStream maps a Binance message of any stream to a book event, books are keyed by market
+func convertStream(market binance.Market, streamResp binance.DepthStreamResponse, target *internalServices.BookEvent) error {
+	target.Stream = streamResp.Stream
+	target.Venue = internalServices.DefaultVenue
+
+	switch streamResp.Kind {
+	case binance.StreamBookTicker:
+		t := streamResp.BookTicker
+
+		values, err := parseFloats(t.BidPrice, t.BidQty, t.AskPrice, t.AskQty)
+		if err != nil {
+			return fmt.Errorf("bookTicker: %w", err)
+		}
+
+		target.Kind = internalServices.BookEventTicker
+		target.Symbol = market.BookSymbol(t.Symbol)
+		target.Ticker = internalServices.TopOfBook{Bid: values[0], BidQty: values[1], Ask: values[2], AskQty: values[3]}
+	case binance.StreamAggTrade:
+		t := streamResp.AggTrade
+
+		values, err := parseFloats(t.Price, t.Quantity)
+		if err != nil {
+			return fmt.Errorf("aggTrade: %w", err)
+		}
+
+		target.Kind = internalServices.BookEventTrade
+		target.Symbol = market.BookSymbol(t.Symbol)
+		target.Trade = internalServices.Trade{Price: values[0], Quantity: values[1], Time: time.UnixMilli(t.TradeTime)}
+	default:
+		bids, err := internalServices.ParseLevels(streamResp.Data.Bids)
+		if err != nil {
+			return fmt.Errorf("bids: %w", err)
+		}
+
+		asks, err := internalServices.ParseLevels(streamResp.Data.Asks)
+		if err != nil {
+			return fmt.Errorf("asks: %w", err)
+		}
+
+		target.Kind = internalServices.BookEventDiff
+		if streamResp.Snapshot {
+			target.Kind = internalServices.BookEventSnapshot
+		}
+
+		target.Symbol = market.BookSymbol(streamResp.Data.Symbol)
+		target.Bids, target.Asks = bids, asks
+	}
+
+	return nil
+}
+
+func parseFloats(raw ...string) ([]float64, error) {
+	res := make([]float64, len(raw))
+
+	for i, r := range raw {
+		v, err := strconv.ParseFloat(r, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		res[i] = v
+	}
+
+	return res, nil
+}
@@ -0,0 +1,199 @@
+package adapters
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aggregate-binance-depth/internal/capture"
+	"github.com/aggregate-binance-depth/internal/metrics"
+	internalServices "github.com/aggregate-binance-depth/internal/services"
+	"github.com/aggregate-binance-depth/services"
+	"github.com/aggregate-binance-depth/services/binance"
+	"github.com/aggregate-binance-depth/services/okx"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxRecentDiffs bounds the diffs kept per Binance book to follow a recorded snapshot,
+// the live sync buffers no more than the updates received while a snapshot is fetched
+const maxRecentDiffs = 1000
+
+// DepthReplayAdapter is a DepthReader playing recorded upstream frames instead of the
+// exchanges, ReadJSON returns io.EOF once the capture ends. Frames are applied as recorded
+// and the REST snapshots a live Binance book synced from are replayed when they were fetched.
+type DepthReplayAdapter struct {
+	Player *capture.Player
+	// Source names the capture in status reports
+	Source string
+
+	mu        sync.Mutex
+	startedAt time.Time
+	finished  bool
+	// pending holds events of a frame carrying several, OKX pushes may
+	pending []internalServices.BookEvent
+	// recent holds the latest diffs of each Binance book, a snapshot is recorded once fetched
+	// so the diffs received meanwhile are applied again after it, as the live sync did
+	recent map[string][]recentDiff
+}
+
+type recentDiff struct {
+	finalUpdateId int64
+	event         internalServices.BookEvent
+}
+
+func (a *DepthReplayAdapter) ReadJSON(target *internalServices.BookEvent) error {
+	for len(a.pending) == 0 {
+		if err := a.next(); err != nil {
+			return err
+		}
+	}
+
+	*target = a.pending[0]
+	a.pending = a.pending[1:]
+
+	return nil
+}
+
+func (a *DepthReplayAdapter) next() error {
+	rec, err := a.Player.Next()
+
+	a.mu.Lock()
+	if a.startedAt.IsZero() {
+		a.startedAt = time.Now()
+	}
+	a.finished = err != nil
+	a.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	_, span := tracer.Start(context.Background(), "replay.read", trace.WithAttributes(attribute.Int("bytes", len(rec.Frame))))
+	defer span.End()
+
+	events, err := a.decodeRecord(rec, span.SpanContext())
+
+	if err != nil {
+		metrics.DecodeErrors.WithLabelValues("upstream").Inc()
+		span.SetStatus(codes.Error, err.Error())
+
+		return err
+	}
+
+	a.pending = append(a.pending, events...)
+
+	return nil
+}
+
+// decodeRecord converts a frame by the venue it was recorded from, Binance frames are recorded
+// under their market and snapshots under its SnapshotSource. Frames without book data,
+// e.g. subscription acknowledgements, yield none.
+func (a *DepthReplayAdapter) decodeRecord(rec capture.Record, sc trace.SpanContext) ([]internalServices.BookEvent, error) {
+	if rec.Source == okx.Venue {
+		var msg okx.Message
+
+		ok, err := okx.DecodeFrame(rec.Frame, &msg)
+		if err != nil || !ok || msg.Event != "" || msg.Arg.Channel != okx.ChannelBooks {
+			return nil, err
+		}
+
+		metrics.UpstreamMessages.WithLabelValues(msg.Arg.Channel + ":" + msg.Arg.InstId).Inc()
+
+		res := make([]internalServices.BookEvent, 0, len(msg.Data))
+
+		for _, data := range msg.Data {
+			e := internalServices.BookEvent{Trace: sc}
+
+			if err := convertBooks(msg.Arg.InstId, msg.Action == okx.ActionSnapshot, data, &e); err != nil {
+				return nil, err
+			}
+
+			res = append(res, e)
+		}
+
+		return res, nil
+	}
+
+	if market, ok := binance.SnapshotMarket(rec.Source); ok {
+		return a.decodeSnapshot(market, rec, sc)
+	}
+
+	var streamResp binance.DepthStreamResponse
+
+	if err := binance.DecodeStreamFrame(rec.Frame, &streamResp); err != nil {
+		return nil, err
+	}
+
+	metrics.UpstreamMessages.WithLabelValues(streamResp.Stream).Inc()
+
+	e := internalServices.BookEvent{Trace: sc}
+
+	if err := convertStream(binance.Market(rec.Source), streamResp, &e); err != nil {
+		return nil, err
+	}
+
+	if e.Kind == internalServices.BookEventDiff {
+		a.remember(e.Symbol, recentDiff{finalUpdateId: streamResp.Data.FinalUpdateId, event: e})
+	}
+
+	return []internalServices.BookEvent{e}, nil
+}
+
+// decodeSnapshot replaces the book with a recorded snapshot followed by the recent diffs it does not cover
+func (a *DepthReplayAdapter) decodeSnapshot(market binance.Market, rec capture.Record, sc trace.SpanContext) ([]internalServices.BookEvent, error) {
+	var streamResp binance.DepthStreamResponse
+
+	if err := binance.DecodeSnapshotFrame(rec.Frame, &streamResp); err != nil {
+		return nil, err
+	}
+
+	e := internalServices.BookEvent{Trace: sc}
+
+	if err := convertStream(market, streamResp, &e); err != nil {
+		return nil, err
+	}
+
+	res := []internalServices.BookEvent{e}
+	following := make([]recentDiff, 0)
+
+	for _, diff := range a.recent[e.Symbol] {
+		if diff.finalUpdateId > streamResp.Data.FinalUpdateId {
+			res = append(res, diff.event)
+			following = append(following, diff)
+		}
+	}
+
+	if a.recent != nil {
+		a.recent[e.Symbol] = following
+	}
+
+	return res, nil
+}
+
+func (a *DepthReplayAdapter) remember(s string, diff recentDiff) {
+	if a.recent == nil {
+		a.recent = make(map[string][]recentDiff)
+	}
+
+	recent := a.recent[s]
+
+	if len(recent) >= maxRecentDiffs {
+		recent = recent[1:]
+	}
+
+	a.recent[s] = append(recent, diff)
+}
+
+// Status reports the replay as a connected upstream until the capture ends
+func (a *DepthReplayAdapter) Status() services.ConnectionStatus {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return services.ConnectionStatus{
+		Url:         a.Source,
+		Connected:   !a.startedAt.IsZero() && !a.finished,
+		ConnectedAt: a.startedAt,
+	}
+}
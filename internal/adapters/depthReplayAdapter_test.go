@@ -0,0 +1,62 @@
+package adapters
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aggregate-binance-depth/internal/capture"
+	internalServices "github.com/aggregate-binance-depth/internal/services"
+	"github.com/aggregate-binance-depth/services/binance"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func diffRecord(firstUpdateId, finalUpdateId int, bid string) capture.Record {
+	frame := fmt.Sprintf(`{"stream":"btcusdt@depth@100ms","data":{"e":"depthUpdate","s":"BTCUSDT","U":%d,"u":%d,"b":[["%s","1"]],"a":[]}}`,
+		firstUpdateId, finalUpdateId, bid)
+
+	return capture.Record{Source: string(binance.MarketSpot), Frame: []byte(frame)}
+}
+
+func TestReplayAppliesRecordedSnapshots(t *testing.T) {
+	var a DepthReplayAdapter
+
+	records := []capture.Record{diffRecord(101, 105, "10"), diffRecord(106, 110, "11")}
+
+	for _, rec := range records {
+		events, err := a.decodeRecord(rec, trace.SpanContext{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(events) != 1 || events[0].Kind != internalServices.BookEventDiff {
+			t.Fatalf("diff yielded %+v", events)
+		}
+	}
+
+	// fetched while the second diff was on its way, the snapshot covers only the first one
+	frame, err := binance.EncodeSnapshotFrame("BTCUSDT", binance.DepthSnapshot{
+		LastUpdateId: 107,
+		Bids:         [][]string{{"9", "2"}},
+		Asks:         [][]string{{"12", "3"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := a.decodeRecord(capture.Record{Source: binance.MarketSpot.SnapshotSource(), Frame: frame}, trace.SpanContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("snapshot yielded %d events, want the snapshot and the diff after it", len(events))
+	}
+
+	if e := events[0]; e.Kind != internalServices.BookEventSnapshot || e.Symbol != "BTCUSDT" || len(e.Bids) != 1 || e.Bids[0].Price != 9 {
+		t.Errorf("snapshot event = %+v", e)
+	}
+
+	if e := events[1]; e.Kind != internalServices.BookEventDiff || e.Bids[0].Price != 11 {
+		t.Errorf("event after the snapshot = %+v, want the diff ending at 110", e)
+	}
+}
@@ -1,24 +1,37 @@
 package adapters
 
 import (
+	"github.com/aggregate-binance-depth/internal/metrics"
 	internalServices "github.com/aggregate-binance-depth/internal/services"
+	"github.com/aggregate-binance-depth/internal/tracing"
 	"github.com/aggregate-binance-depth/services/binance"
+	"go.opentelemetry.io/otel/codes"
 )
 
+var tracer = tracing.Tracer("github.com/aggregate-binance-depth/internal/adapters")
+
 type DepthServiceWsAdapter struct {
 	DepthService *binance.DepthServiceWs
 }
 
-func (a *DepthServiceWsAdapter) ReadJSON(target *internalServices.DepthReaderResponse) error {
+func (a *DepthServiceWsAdapter) ReadJSON(target *internalServices.BookEvent) error {
 	var streamResp binance.DepthStreamResponse
 	err := a.DepthService.ReadJSON(&streamResp)
 	if err != nil {
 		return err
 	}
 
-	target.Stream = streamResp.Stream
-	target.Data.Symbol = streamResp.Data.Symbol
-	target.Data.Bids = streamResp.Data.Bids
-	target.Data.Asks = streamResp.Data.Asks
+	_, span := tracer.Start(tracing.ContextWith(streamResp.Trace), "adapter.convert")
+	defer span.End()
+
+	target.Trace = span.SpanContext()
+
+	if err := convertStream(a.DepthService.Market(), streamResp, target); err != nil {
+		metrics.DecodeErrors.WithLabelValues("upstream").Inc()
+		span.SetStatus(codes.Error, err.Error())
+
+		return err
+	}
+
 	return nil
 }
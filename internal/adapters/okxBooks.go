@@ -0,0 +1,33 @@
+package adapters
+
+import (
+	"fmt"
+
+	internalServices "github.com/aggregate-binance-depth/internal/services"
+	"github.com/aggregate-binance-depth/services/okx"
+)
+
+// convertBooks maps an OKX books push to a book event keyed by venue, e.g. OKX:BTC-USDT
+func convertBooks(instId string, snapshot bool, data okx.BooksData, target *internalServices.BookEvent) error {
+	bids, err := internalServices.ParseLevels(data.Bids)
+	if err != nil {
+		return fmt.Errorf("bids: %w", err)
+	}
+
+	asks, err := internalServices.ParseLevels(data.Asks)
+	if err != nil {
+		return fmt.Errorf("asks: %w", err)
+	}
+
+	target.Stream = okx.ChannelBooks + ":" + instId
+	target.Venue = okx.Venue
+	target.Kind = internalServices.BookEventDiff
+	if snapshot {
+		target.Kind = internalServices.BookEventSnapshot
+	}
+
+	target.Symbol = internalServices.BookKey(okx.Venue, instId)
+	target.Bids, target.Asks = bids, asks
+
+	return nil
+}
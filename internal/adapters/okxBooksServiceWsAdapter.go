@@ -0,0 +1,35 @@
+package adapters
+
+import (
+	"github.com/aggregate-binance-depth/internal/metrics"
+	internalServices "github.com/aggregate-binance-depth/internal/services"
+	"github.com/aggregate-binance-depth/internal/tracing"
+	"github.com/aggregate-binance-depth/services/okx"
+	"go.opentelemetry.io/otel/codes"
+)
+
+type OkxBooksServiceWsAdapter struct {
+	BooksService *okx.BooksServiceWs
+}
+
+func (a *OkxBooksServiceWsAdapter) ReadJSON(target *internalServices.BookEvent) error {
+	var resp okx.BooksResponse
+	err := a.BooksService.ReadJSON(&resp)
+	if err != nil {
+		return err
+	}
+
+	_, span := tracer.Start(tracing.ContextWith(resp.Trace), "adapter.convert")
+	defer span.End()
+
+	target.Trace = span.SpanContext()
+
+	if err := convertBooks(resp.InstId, resp.Snapshot, resp.Data, target); err != nil {
+		metrics.DecodeErrors.WithLabelValues("upstream").Inc()
+		span.SetStatus(codes.Error, err.Error())
+
+		return err
+	}
+
+	return nil
+}
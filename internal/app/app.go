@@ -1,55 +1,482 @@
 package app
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"log/slog"
+	"slices"
+	"time"
 
 	"github.com/aggregate-binance-depth/infra"
 	"github.com/aggregate-binance-depth/internal/adapters"
+	"github.com/aggregate-binance-depth/internal/capture"
+	"github.com/aggregate-binance-depth/internal/config"
+	"github.com/aggregate-binance-depth/internal/metrics"
+	"github.com/aggregate-binance-depth/internal/persistence"
 	internalServices "github.com/aggregate-binance-depth/internal/services"
+	"github.com/aggregate-binance-depth/rpc"
 	"github.com/aggregate-binance-depth/services"
 	"github.com/aggregate-binance-depth/services/binance"
+	"github.com/aggregate-binance-depth/services/okx"
 	"github.com/aggregate-binance-depth/ws"
 )
 
 type App struct {
 	DepthGateService *internalServices.DepthGateService
 	WsServer         *ws.WebsocketServer
-	Wss              *services.WsService
+	GrpcServer       *rpc.GrpcServer
+	// Wss are the upstream connections, one per Binance market and one for OKX, none when replaying a capture
+	Wss []*services.WsService
+	// Bars turns published tops of book into bars and closes them on time
+	Bars *internalServices.Bars
+	// Snapshots is nil unless persisting books is enabled
+	Snapshots *persistence.Store
+	// Recorder is nil unless capturing upstream frames is enabled
+	Recorder *capture.Recorder
 }
 
-func NewApp(l *slog.Logger, symbols []string) (*App, error) {
+func NewApp(l *slog.Logger, cfg *config.Config) (*App, error) {
 	const op = "internal.app.NewApp"
 
 	logger := l.With(slog.String("op", op))
 
-	var wsc infra.WebsocketConnection
+	registry, err := newRegistry(cfg)
 
-	wss, err := services.NewWsService(l, wsc)
+	if err != nil {
+		logger.Error("error with symbols", slog.String("error", err.Error()))
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := describeInstruments(l, cfg, registry); err != nil {
+		logger.Error("error with instruments", slog.String("error", err.Error()))
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	markets := venueSymbols(registry, internalServices.DefaultVenue)
+	upstreamTraffic := &infra.TrafficStats{}
+
+	var reader internalServices.DepthReader
+	var shards []upstream
+	var wss []*services.WsService
+	// bookUpstreams is the upstream serving each book, bars of a book closing while it is down are stale
+	bookUpstreams := make(map[string]upstream)
+	var recorder *capture.Recorder
+
+	if cfg.Replay.Path != "" {
+		replay, err := newReplayAdapter(cfg.Replay)
+
+		if err != nil {
+			logger.Error("error with create replay", slog.String("error", err.Error()))
+
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		logger.Info("replaying capture", slog.String("path", cfg.Replay.Path), slog.Float64("speed", cfg.Replay.Speed))
+
+		reader, shards = replay, []upstream{replay}
+	} else {
+		if cfg.Binance.Recorder.Enabled {
+			if recorder, err = newRecorder(l, cfg.Binance.Recorder); err != nil {
+				logger.Error("error with create recorder", slog.String("error", err.Error()))
+
+				return nil, fmt.Errorf("%s: %w", op, err)
+			}
+		}
+
+		readers := make([]internalServices.DepthReader, 0, len(markets)+1)
+
+		// one upstream connection per market, markets live on different hosts
+		for _, market := range binance.Markets {
+			if len(markets[string(market)]) == 0 {
+				continue
+			}
+
+			marketWss, depthServiceWs, err := newBinanceUpstream(l, cfg.Binance, market, markets[string(market)], upstreamTraffic, recorder)
+
+			if err != nil {
+				logger.Error("error with create upstream", slog.String("market", string(market)), slog.String("error", err.Error()))
+
+				return nil, fmt.Errorf("%s: %w", op, err)
+			}
+
+			wss = append(wss, marketWss)
+			shards = append(shards, marketWss)
+			addBookUpstreams(bookUpstreams, registry, internalServices.DefaultVenue, string(market), marketWss)
+			readers = append(readers, &adapters.DepthServiceWsAdapter{DepthService: depthServiceWs})
+		}
+
+		if instIds := venueSymbolList(registry, okx.Venue); len(instIds) > 0 {
+			okxWss, booksServiceWs, err := newOkxUpstream(l, cfg.Okx, instIds, upstreamTraffic, recorder)
+
+			if err != nil {
+				logger.Error("error with create upstream", slog.String("venue", okx.Venue), slog.String("error", err.Error()))
+
+				return nil, fmt.Errorf("%s: %w", op, err)
+			}
+
+			wss = append(wss, okxWss)
+			shards = append(shards, okxWss)
+			addBookUpstreams(bookUpstreams, registry, okx.Venue, "", okxWss)
+			readers = append(readers, &adapters.OkxBooksServiceWsAdapter{BooksService: booksServiceWs})
+		}
+
+		if len(readers) == 1 {
+			reader = readers[0]
+		} else {
+			reader = internalServices.NewDepthReaders(readers...)
+		}
+	}
+
+	wsServer, err := ws.NewWebsocketServer(l, ws.Options{
+		Address:     listenAddress(cfg.Wss.Address, cfg.Wss.Port),
+		Compression: infra.Compression(cfg.Wss.Compression),
+		Auth:        wsAuthOptions(cfg.Wss.Auth),
+		TLS:         tlsFiles(cfg.Wss.TLS),
+		Limits:      ws.Limits(cfg.Wss.Limits),
+	})
 
 	if err != nil {
-		logger.Error("error with create websocket service", slog.String("error", err.Error()))
+		logger.Error("error with create websocket server", slog.String("error", err.Error()))
 
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	depthServiceWs, err := binance.NewDepthServiceWs(l, symbols, wss)
+	grpcServer, err := rpc.NewGrpcServer(l, rpc.Options{
+		Address: listenAddress(cfg.Grpc.Address, cfg.Grpc.Port),
+		TLS:     tlsFiles(cfg.Grpc.TLS),
+	})
 
 	if err != nil {
-		logger.Error("error with create depth service", slog.String("error", err.Error()))
+		logger.Error("error with create grpc server", slog.String("error", err.Error()))
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	bars, err := internalServices.NewBars(l, cfg.Bars.Intervals, cfg.Bars.History, wsServer)
+
+	if err != nil {
+		logger.Error("error with create bars", slog.String("error", err.Error()))
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	depthGateService := internalServices.NewDepthGateService(
+		l,
+		registry.Symbols(),
+		reader,
+		internalServices.DepthWriters{wsServer, grpcServer, bars},
+	)
+
+	consolidatedBooks := make(map[string][]string, len(cfg.Consolidated))
+
+	for _, consolidated := range cfg.Consolidated {
+		key, err := depthGateService.Consolidate(consolidated.Symbol, consolidated.Books)
+		if err != nil {
+			logger.Error("error with consolidate", slog.String("symbol", consolidated.Symbol), slog.String("error", err.Error()))
+
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		for _, book := range consolidated.Books {
+			consolidatedBooks[key] = append(consolidatedBooks[key], internalServices.NormalizeBookKey(book))
+		}
+	}
+
+	bars.SetConnected(upstreamConnected(bookUpstreams, consolidatedBooks))
+
+	// books are grouped by multiples of their tick size, books without metadata infer it from their prices
+	for _, instrument := range registry.All() {
+		if instrument.TickSize > 0 {
+			depthGateService.SetTickSize(instrument.Symbol, instrument.TickSize)
+		}
+	}
+
+	if err := depthGateService.SetLiquidityBands(cfg.Wss.LiquidityBps); err != nil {
+		logger.Error("error with liquidity bands", slog.String("error", err.Error()))
 
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	wsServer := ws.NewWebsocketServer(l)
+	var snapshots *persistence.Store
+
+	if cfg.Snapshots.Enabled && cfg.Replay.Path == "" {
+		restoreBooks(l, cfg.Snapshots, depthGateService)
 
-	depthGateService := internalServices.NewDepthGateService(l, &adapters.DepthServiceWsAdapter{DepthService: depthServiceWs}, wsServer)
+		snapshots, err = persistence.NewStore(l, persistence.Options{Path: cfg.Snapshots.Path, Interval: cfg.Snapshots.Interval}, depthGateService)
+
+		if err != nil {
+			logger.Error("error with create snapshots", slog.String("error", err.Error()))
+
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
 
 	wsServer.RegisterDepthGateService(depthGateService)
+	wsServer.RegisterInstruments(registry)
+	wsServer.RegisterBars(bars)
+	wsServer.RegisterTrafficStats("upstream", upstreamTraffic)
+	for _, shard := range shards {
+		wsServer.RegisterShard(shard)
+	}
+	grpcServer.RegisterDepthGateService(depthGateService)
+	grpcServer.RegisterAuthenticator(wsServer)
+
+	if err := metrics.RegisterSymbolAges(depthGateService); err != nil {
+		logger.Error("error with register symbol ages", slog.String("error", err.Error()))
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
 
 	return &App{
 		DepthGateService: depthGateService,
 		WsServer:         wsServer,
+		GrpcServer:       grpcServer,
 		Wss:              wss,
+		Bars:             bars,
+		Snapshots:        snapshots,
+		Recorder:         recorder,
 	}, nil
 }
+
+// upstream is the source of depth updates reported by health endpoints
+type upstream interface {
+	Status() services.ConnectionStatus
+}
+
+// upstreamConnected reports whether the upstreams serving a symbol are connected, those of every book
+// of a consolidated one. Symbols no upstream serves, e.g. when replaying a capture, count as connected.
+func upstreamConnected(upstreams map[string]upstream, consolidated map[string][]string) func(symbol string) bool {
+	return func(symbol string) bool {
+		books, ok := consolidated[symbol]
+		if !ok {
+			books = []string{symbol}
+		}
+
+		for _, book := range books {
+			if u, ok := upstreams[book]; ok && !u.Status().Connected {
+				return false
+			}
+		}
+
+		return true
+	}
+}
+
+func newRecorder(l *slog.Logger, cfg config.Recorder) (*capture.Recorder, error) {
+	return capture.NewRecorder(l, capture.Options{
+		Dir:            cfg.Dir,
+		MaxFileBytes:   cfg.MaxFileBytes,
+		MaxFileAge:     cfg.MaxFileAge,
+		RetentionBytes: cfg.RetentionBytes,
+		RetentionAge:   cfg.RetentionAge,
+	})
+}
+
+// restoreBooks warm starts the gate from the last checkpoint, a missing, unreadable or too old one is skipped
+// and so are books last updated longer than MaxAge ago, e.g. stale ones saved again while the upstream was down
+func restoreBooks(l *slog.Logger, cfg config.Snapshots, gate *internalServices.DepthGateService) {
+	const op = "internal.app.restoreBooks"
+
+	logger := l.With(slog.String("op", op), slog.String("path", cfg.Path))
+
+	cp, err := persistence.Load(cfg.Path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			logger.Info("no checkpoint to restore")
+
+			return
+		}
+
+		logger.Warn("error with Load", slog.String("error", err.Error()))
+
+		return
+	}
+
+	if age := time.Since(cp.SavedAt); cfg.MaxAge > 0 && age > cfg.MaxAge {
+		logger.Warn("checkpoint is too old to restore", slog.Duration("age", age))
+
+		return
+	}
+
+	if cfg.MaxAge > 0 {
+		books := len(cp.Books)
+
+		cp.Books = slices.DeleteFunc(cp.Books, func(saved internalServices.BookCheckpoint) bool {
+			return time.Since(saved.Book.UpdatedAt) > cfg.MaxAge
+		})
+
+		if skipped := books - len(cp.Books); skipped > 0 {
+			logger.Warn("books are too old to restore", slog.Int("books", skipped))
+		}
+	}
+
+	restored := gate.Restore(cp)
+
+	logger.Info("books restored as stale", slog.Int("books", restored), slog.Time("savedAt", cp.SavedAt))
+}
+
+// newBinanceUpstream connects the depth streams of market's symbols, teeing frames to recorder when set
+func newBinanceUpstream(
+	l *slog.Logger,
+	cfg config.Binance,
+	market binance.Market,
+	symbols []string,
+	traffic *infra.TrafficStats,
+	recorder *capture.Recorder,
+) (*services.WsService, *binance.DepthServiceWs, error) {
+	endpoints, err := binanceEndpoints(cfg, market)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	wsc := infra.WebsocketConnection{
+		Compression: infra.Compression(cfg.Compression),
+		Stats:       traffic,
+		Keepalive:   cfg.Keepalive,
+	}
+
+	wss, err := services.NewWsService(l, wsc)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	wss.SetSource(string(market))
+
+	if recorder != nil {
+		wss.SetRecorder(recorder)
+	}
+
+	streams := make([]binance.StreamKind, 0, len(cfg.Depth.Streams))
+
+	for _, stream := range cfg.Depth.Streams {
+		streams = append(streams, binance.StreamKind(stream))
+	}
+
+	depthServiceWs, err := binance.NewDepthServiceWs(l, endpoints, symbols, wss, streams...)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if recorder != nil {
+		depthServiceWs.SetRecorder(recorder)
+	}
+
+	return wss, depthServiceWs, nil
+}
+
+// newOkxUpstream connects the books channel of the instruments, teeing frames to recorder when set
+func newOkxUpstream(
+	l *slog.Logger,
+	cfg config.Okx,
+	instIds []string,
+	traffic *infra.TrafficStats,
+	recorder *capture.Recorder,
+) (*services.WsService, *okx.BooksServiceWs, error) {
+	wsc := infra.WebsocketConnection{
+		Compression: infra.Compression(cfg.Compression),
+		Stats:       traffic,
+	}
+
+	wss, err := services.NewWsService(l, wsc)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	wss.SetSource(okx.Venue)
+
+	if recorder != nil {
+		wss.SetRecorder(recorder)
+	}
+
+	booksServiceWs, err := okx.NewBooksServiceWs(l, okx.NewOkx(cfg.WsHosts, cfg.RestBaseUrl), instIds, wss)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return wss, booksServiceWs, nil
+}
+
+// binanceEndpoints resolves the market's hosts, the custom env shares the spot hosts unless overridden
+func binanceEndpoints(cfg config.Binance, market binance.Market) (binance.Binance, error) {
+	wsHosts, restBaseUrl := cfg.WsHosts, cfg.RestBaseUrl
+
+	var override config.Endpoints
+
+	switch market {
+	case binance.MarketUSDM:
+		override = cfg.USDM
+	case binance.MarketCOINM:
+		override = cfg.COINM
+	}
+
+	if market != binance.MarketSpot && cfg.Env != binance.EnvCustom {
+		wsHosts, restBaseUrl = nil, ""
+	}
+
+	if len(override.WsHosts) > 0 {
+		wsHosts = override.WsHosts
+	}
+
+	if override.RestBaseUrl != "" {
+		restBaseUrl = override.RestBaseUrl
+	}
+
+	return binance.NewBinance(cfg.Env, market, wsHosts, restBaseUrl)
+}
+
+func newReplayAdapter(cfg config.Replay) (*adapters.DepthReplayAdapter, error) {
+	player, err := capture.NewPlayer(cfg.Path, cfg.Speed)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &adapters.DepthReplayAdapter{Player: player, Source: "replay://" + cfg.Path}, nil
+}
+
+func wsAuthOptions(cfg config.Auth) ws.AuthOptions {
+	keys := make([]ws.APIKey, 0, len(cfg.APIKeys))
+
+	for _, key := range cfg.APIKeys {
+		keys = append(keys, ws.APIKey(key))
+	}
+
+	return ws.AuthOptions{
+		Mode:           cfg.Mode,
+		APIKeys:        keys,
+		HMACSecret:     cfg.HMACSecret,
+		JWKSPath:       cfg.JWKSPath,
+		JWTIssuer:      cfg.JWTIssuer,
+		JWTAudience:    cfg.JWTAudience,
+		AllowedOrigins: cfg.AllowedOrigins,
+	}
+}
+
+// listenAddress is address when set, else localhost:port
+func listenAddress(address string, port int) string {
+	if address != "" {
+		return address
+	}
+
+	return fmt.Sprintf("localhost:%d", port)
+}
+
+func tlsFiles(cfg config.TLS) *infra.TLSFiles {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	return &infra.TLSFiles{
+		CertFile:     cfg.CertFile,
+		KeyFile:      cfg.KeyFile,
+		ClientCAFile: cfg.ClientCAFile,
+	}
+}
@@ -0,0 +1,204 @@
+package app
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aggregate-binance-depth/internal/config"
+	internalServices "github.com/aggregate-binance-depth/internal/services"
+	"github.com/aggregate-binance-depth/services/binance"
+	"github.com/aggregate-binance-depth/services/binance/binancetest"
+)
+
+const waitTimeout = 10 * time.Second
+
+// waitForBook polls the gate until the book of symbol passes check
+func waitForBook(t *testing.T, gate *internalServices.DepthGateService, symbol string, check func(internalServices.BookSnapshot) bool) internalServices.BookSnapshot {
+	t.Helper()
+
+	deadline := time.Now().Add(waitTimeout)
+
+	for {
+		book, ok := gate.Book(symbol, 0)
+		if ok && check(book) {
+			return book
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("book %s = %+v, still not as expected after %s", symbol, book, waitTimeout)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func hasBid(p, q float64) func(internalServices.BookSnapshot) bool {
+	return func(book internalServices.BookSnapshot) bool {
+		for _, level := range book.Bids {
+			if level.Price == p {
+				return level.Quantity == q
+			}
+		}
+
+		return false
+	}
+}
+
+// TestAppAgainstFakeBinance runs the app on a fake upstream, the scenarios share one app
+// as the metrics of an app are registered once per process
+func TestAppAgainstFakeBinance(t *testing.T) {
+	fake := binancetest.NewServer()
+	t.Cleanup(fake.Close)
+
+	fake.SetSymbolInfo(binancetest.SymbolInfo{Symbol: "BTCUSDT", BaseAsset: "BTC", QuoteAsset: "USDT", TickSize: "0.01", StepSize: "0.00001"})
+	fake.SetSnapshot("BTCUSDT", binancetest.Snapshot{
+		LastUpdateId: 100,
+		Bids:         [][]string{{"100", "1"}},
+		Asks:         [][]string{{"101", "1"}},
+	})
+
+	cfg := &config.Config{Env: "local"}
+	cfg.Binance.Env = binance.EnvCustom
+	cfg.Binance.WsHosts = []string{fake.WsHost}
+	cfg.Binance.RestBaseUrl = fake.RestURL
+	cfg.Binance.Keepalive = 100 * time.Millisecond
+	cfg.Binance.Depth.Symbols = []string{"btcusdt"}
+	cfg.Instruments.Source = InstrumentsSourceExchange
+
+	application, err := NewApp(slog.New(slog.NewTextHandler(io.Discard, nil)), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go application.DepthGateService.Serve()
+
+	t.Cleanup(func() {
+		application.DepthGateService.Shutdown()
+
+		for _, wss := range application.Wss {
+			wss.Disconnect()
+		}
+	})
+
+	if err := fake.WaitForConnection(waitTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	gate := application.DepthGateService
+	upstream := application.Wss[0]
+
+	t.Run("emitted updates apply on the snapshot", func(t *testing.T) {
+		if err := fake.EmitDepth("BTCUSDT", binancetest.DepthEvent{Bids: [][]string{{"100.5", "2"}}}); err != nil {
+			t.Fatal(err)
+		}
+
+		book := waitForBook(t, gate, "BTCUSDT", hasBid(100.5, 2))
+
+		if !hasBid(100, 1)(book) {
+			t.Errorf("bids = %+v, want the snapshot's 100 next to the update", book.Bids)
+		}
+	})
+
+	t.Run("gap resyncs from a new snapshot", func(t *testing.T) {
+		fake.InjectGap("BTCUSDT", 5)
+		fake.SetSnapshot("BTCUSDT", binancetest.Snapshot{
+			LastUpdateId: 106,
+			Bids:         [][]string{{"99", "3"}},
+			Asks:         [][]string{{"102", "1"}},
+		})
+
+		if err := fake.EmitDepth("BTCUSDT", binancetest.DepthEvent{Bids: [][]string{{"99.5", "4"}}}); err != nil {
+			t.Fatal(err)
+		}
+
+		book := waitForBook(t, gate, "BTCUSDT", hasBid(99.5, 4))
+
+		if !hasBid(99, 3)(book) || hasBid(100.5, 2)(book) {
+			t.Errorf("bids = %+v, want the new snapshot's levels only", book.Bids)
+		}
+	})
+
+	t.Run("dropped connection reconnects", func(t *testing.T) {
+		reconnects := upstream.Status().Reconnects
+
+		fake.DropConnections()
+
+		if err := fake.WaitForConnection(waitTimeout); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := fake.EmitDepth("BTCUSDT", binancetest.DepthEvent{Bids: [][]string{{"98", "5"}}}); err != nil {
+			t.Fatal(err)
+		}
+
+		waitForBook(t, gate, "BTCUSDT", hasBid(98, 5))
+
+		if got := upstream.Status().Reconnects; got <= reconnects {
+			t.Errorf("reconnects = %d, want more than %d", got, reconnects)
+		}
+	})
+
+	t.Run("delayed pong reconnects", func(t *testing.T) {
+		reconnects := upstream.Status().Reconnects
+
+		fake.DelayPongs(time.Second)
+
+		if err := fake.WaitForConnection(waitTimeout); err != nil {
+			t.Fatal(err)
+		}
+
+		fake.DelayPongs(0)
+
+		if err := fake.EmitDepth("BTCUSDT", binancetest.DepthEvent{Bids: [][]string{{"97", "6"}}}); err != nil {
+			t.Fatal(err)
+		}
+
+		waitForBook(t, gate, "BTCUSDT", hasBid(97, 6))
+
+		if got := upstream.Status().Reconnects; got <= reconnects {
+			t.Errorf("reconnects = %d, want more than %d", got, reconnects)
+		}
+	})
+}
+
+func TestRestoreBooksSkipsBooksOlderThanMaxAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "books.json")
+	book := func(s string, age time.Duration) internalServices.BookCheckpoint {
+		return internalServices.BookCheckpoint{Book: internalServices.BookSnapshot{
+			Symbol:    s,
+			Bids:      []internalServices.PriceLevel{{Price: 100, Quantity: 1}},
+			Asks:      []internalServices.PriceLevel{{Price: 101, Quantity: 1}},
+			UpdatedAt: time.Now().Add(-age),
+		}}
+	}
+
+	// a fresh checkpoint can hold a stale book saved again while its upstream was down
+	data, err := json.Marshal(internalServices.Checkpoint{
+		SavedAt: time.Now(),
+		Books:   []internalServices.BookCheckpoint{book("BTCUSDT", time.Minute), book("ETHUSDT", 2*time.Hour)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	gate := internalServices.NewDepthGateService(nil, []string{"btcusdt", "ethusdt"}, nil, internalServices.DepthWriters{})
+
+	restoreBooks(slog.New(slog.NewTextHandler(io.Discard, nil)), config.Snapshots{Path: path, MaxAge: time.Hour}, gate)
+
+	if _, ok := gate.Book("BTCUSDT", 0); !ok {
+		t.Error("BTCUSDT is not restored, want it as it is a minute old")
+	}
+
+	if _, ok := gate.Book("ETHUSDT", 0); ok {
+		t.Error("ETHUSDT is restored, want it skipped as it is older than MaxAge")
+	}
+}
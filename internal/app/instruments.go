@@ -0,0 +1,227 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/aggregate-binance-depth/internal/config"
+	"github.com/aggregate-binance-depth/internal/instruments"
+	internalServices "github.com/aggregate-binance-depth/internal/services"
+	"github.com/aggregate-binance-depth/services/binance"
+	"github.com/aggregate-binance-depth/services/okx"
+)
+
+// Sources of instrument metadata
+const (
+	InstrumentsSourceExchange = "exchange"
+	InstrumentsSourceFile     = "file"
+	InstrumentsSourceNone     = "none"
+)
+
+// newRegistry tracks every configured instrument once under its canonical symbol,
+// e.g. btcusdt and BTCUSDT in the config are one book
+func newRegistry(cfg *config.Config) (*instruments.Registry, error) {
+	registry := instruments.NewRegistry()
+
+	addBinance := func(market binance.Market, symbol string) {
+		registry.Add(instruments.Instrument{
+			Symbol:      market.BookSymbol(symbol),
+			Venue:       internalServices.DefaultVenue,
+			Market:      string(market),
+			VenueSymbol: strings.ToUpper(symbol),
+		})
+	}
+
+	for _, symbol := range cfg.Binance.Depth.Symbols {
+		addBinance(binance.MarketSpot, symbol)
+	}
+
+	for _, instrument := range cfg.Binance.Depth.Instruments {
+		market := binance.Market(instrument.Market)
+
+		// cleanenv does not default fields of slice elements, an instrument without a market is spot
+		if market == "" {
+			market = binance.MarketSpot
+		}
+
+		if !slices.Contains(binance.Markets, market) {
+			return nil, fmt.Errorf("unknown market %q of %s", instrument.Market, instrument.Symbol)
+		}
+
+		addBinance(market, instrument.Symbol)
+	}
+
+	for _, instId := range cfg.Okx.Instruments {
+		registry.Add(instruments.Instrument{
+			Symbol:      internalServices.BookKey(okx.Venue, instId),
+			Venue:       okx.Venue,
+			Market:      strings.ToLower(okx.InstType(instId)),
+			VenueSymbol: strings.ToUpper(instId),
+		})
+	}
+
+	return registry, nil
+}
+
+// venueSymbols groups the venue's own symbols by market in registry order
+func venueSymbols(registry *instruments.Registry, venue string) map[string][]string {
+	res := make(map[string][]string)
+
+	for _, instrument := range registry.All() {
+		if instrument.Venue == venue {
+			res[instrument.Market] = append(res[instrument.Market], instrument.VenueSymbol)
+		}
+	}
+
+	return res
+}
+
+// venueSymbolList is every own symbol of the venue in registry order
+func venueSymbolList(registry *instruments.Registry, venue string) []string {
+	res := make([]string, 0)
+
+	for _, instrument := range registry.All() {
+		if instrument.Venue == venue {
+			res = append(res, instrument.VenueSymbol)
+		}
+	}
+
+	return res
+}
+
+// addBookUpstreams maps the registry's books of venue, and of market unless empty, to u
+func addBookUpstreams(res map[string]upstream, registry *instruments.Registry, venue, market string, u upstream) {
+	for _, instrument := range registry.All() {
+		if instrument.Venue == venue && (market == "" || instrument.Market == market) {
+			res[instrument.Symbol] = u
+		}
+	}
+}
+
+// describeInstruments fills the registry's metadata from the configured source, metadata is
+// informational so a venue failing to describe its instruments is logged and skipped
+func describeInstruments(l *slog.Logger, cfg *config.Config, registry *instruments.Registry) error {
+	const op = "internal.app.describeInstruments"
+
+	logger := l.With(slog.String("op", op))
+
+	switch cfg.Instruments.Source {
+	case InstrumentsSourceNone:
+		return nil
+	case InstrumentsSourceFile:
+		list, err := instruments.LoadFile(cfg.Instruments.Path)
+		if err != nil {
+			return err
+		}
+
+		for _, instrument := range list {
+			if !registry.Describe(instrument) {
+				logger.Debug("instrument is not tracked", slog.String("symbol", instrument.Symbol))
+			}
+		}
+
+		return nil
+	case InstrumentsSourceExchange:
+		if cfg.Replay.Path != "" {
+			logger.Info("replaying, instrument metadata is not fetched")
+
+			return nil
+		}
+
+		if err := describeBinance(cfg.Binance, registry); err != nil {
+			logger.Warn("error with describe instruments", slog.String("venue", internalServices.DefaultVenue), slog.String("error", err.Error()))
+		}
+
+		if err := describeOkx(cfg.Okx, registry); err != nil {
+			logger.Warn("error with describe instruments", slog.String("venue", okx.Venue), slog.String("error", err.Error()))
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("unknown instruments source %q", cfg.Instruments.Source)
+	}
+}
+
+// describeBinance describes the symbols of every market, a market that fails leaves the others described
+func describeBinance(cfg config.Binance, registry *instruments.Registry) error {
+	markets := venueSymbols(registry, internalServices.DefaultVenue)
+	errs := make([]error, 0)
+
+	for _, market := range binance.Markets {
+		symbols := markets[string(market)]
+
+		if len(symbols) == 0 {
+			continue
+		}
+
+		endpoints, err := binanceEndpoints(cfg, market)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", market, err))
+
+			continue
+		}
+
+		infos, err := endpoints.ExchangeInfo(context.Background(), symbols)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", market, err))
+
+			continue
+		}
+
+		for _, info := range infos {
+			registry.Describe(instruments.Instrument{
+				Symbol:   market.BookSymbol(info.Symbol),
+				Base:     info.BaseAsset,
+				Quote:    info.QuoteAsset,
+				TickSize: parseSize(info.TickSize()),
+				StepSize: parseSize(info.StepSize()),
+			})
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func describeOkx(cfg config.Okx, registry *instruments.Registry) error {
+	instIds := venueSymbolList(registry, okx.Venue)
+
+	if len(instIds) == 0 {
+		return nil
+	}
+
+	list, err := okx.NewOkx(cfg.WsHosts, cfg.RestBaseUrl).Instruments(context.Background(), instIds)
+	if err != nil {
+		return err
+	}
+
+	for _, instrument := range list {
+		base, quote := instrument.BaseCcy, instrument.QuoteCcy
+
+		// derivatives name their pair by the underlying, e.g. BTC-USDT
+		if base == "" {
+			base, quote, _ = strings.Cut(instrument.Uly, "-")
+		}
+
+		registry.Describe(instruments.Instrument{
+			Symbol:   internalServices.BookKey(okx.Venue, instrument.InstId),
+			Base:     base,
+			Quote:    quote,
+			TickSize: parseSize(instrument.TickSz),
+			StepSize: parseSize(instrument.LotSz),
+		})
+	}
+
+	return nil
+}
+
+// parseSize reads a tick or step size, unknown sizes are zero
+func parseSize(raw string) float64 {
+	size, _ := strconv.ParseFloat(raw, 64)
+
+	return size
+}
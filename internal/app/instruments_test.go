@@ -0,0 +1,67 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aggregate-binance-depth/internal/config"
+	internalServices "github.com/aggregate-binance-depth/internal/services"
+	"github.com/aggregate-binance-depth/services/binance"
+	"github.com/aggregate-binance-depth/services/binance/binancetest"
+)
+
+func TestNewRegistryDefaultsMarketToSpot(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Binance.Depth.Instruments = []config.Instrument{
+		{Symbol: "btcusdt"},
+		{Symbol: "ethusdt", Market: string(binance.MarketUSDM)},
+	}
+
+	registry, err := newRegistry(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	markets := venueSymbols(registry, internalServices.DefaultVenue)
+
+	if got := markets[string(binance.MarketSpot)]; len(got) != 1 || got[0] != "BTCUSDT" {
+		t.Errorf("spot symbols = %v, want [BTCUSDT]", got)
+	}
+
+	if got := markets[string(binance.MarketUSDM)]; len(got) != 1 || got[0] != "ETHUSDT" {
+		t.Errorf("usdm symbols = %v, want [ETHUSDT]", got)
+	}
+}
+
+func TestDescribeBinanceContinuesPastFailingMarket(t *testing.T) {
+	fake := binancetest.NewServer()
+	t.Cleanup(fake.Close)
+
+	fake.SetSymbolInfo(binancetest.SymbolInfo{Symbol: "ETHUSDT", BaseAsset: "ETH", QuoteAsset: "USDT", TickSize: "0.01", StepSize: "0.001"})
+
+	// spot is described first and its host refuses connections
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+
+	cfg := &config.Config{}
+	cfg.Binance.Env = binance.EnvCustom
+	cfg.Binance.WsHosts = []string{fake.WsHost}
+	cfg.Binance.RestBaseUrl = unreachable.URL
+	cfg.Binance.USDM.RestBaseUrl = fake.RestURL
+	cfg.Binance.Depth.Symbols = []string{"btcusdt"}
+	cfg.Binance.Depth.Instruments = []config.Instrument{{Symbol: "ethusdt", Market: string(binance.MarketUSDM)}}
+
+	registry, err := newRegistry(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := describeBinance(cfg.Binance, registry); err == nil {
+		t.Error("describeBinance succeeded, want the spot error")
+	}
+
+	if instrument, _ := registry.Get("USDM:ETHUSDT"); instrument.TickSize != 0.01 {
+		t.Errorf("USDM:ETHUSDT = %+v, want it described", instrument)
+	}
+}
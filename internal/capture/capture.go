@@ -0,0 +1,37 @@
+package capture
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	filePrefix = "capture-"
+	fileSuffix = ".jsonl.gz"
+	// fileTimeLayout sorts lexically in time order
+	fileTimeLayout = "20060102T150405.000000000Z"
+)
+
+// Record is one upstream frame with its local receive time, a capture file is
+// a gzip stream of records one JSON object per line
+type Record struct {
+	// ReceivedAt is unix nanoseconds
+	ReceivedAt int64 `json:"t"`
+	// Source is the upstream the frame came from, e.g. the market of a Binance frame
+	Source string          `json:"s,omitempty"`
+	Frame  json.RawMessage `json:"f"`
+}
+
+func (r Record) Time() time.Time {
+	return time.Unix(0, r.ReceivedAt)
+}
+
+func fileName(dir string, t time.Time) string {
+	return filepath.Join(dir, filePrefix+t.UTC().Format(fileTimeLayout)+fileSuffix)
+}
+
+func isCaptureFile(name string) bool {
+	return strings.HasPrefix(name, filePrefix) && strings.HasSuffix(name, fileSuffix)
+}
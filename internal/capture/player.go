@@ -0,0 +1,171 @@
+package capture
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// maxLineBytes bounds a single record, depth frames of every symbol at 1000 levels stay well below it
+const maxLineBytes = 16 << 20
+
+// Player reads the records of capture files in order, pacing them by their receive times.
+// Speed 1 plays at the original speed, 10 ten times faster and 0 as fast as possible.
+type Player struct {
+	files []string
+	speed float64
+
+	file    *os.File
+	gz      *gzip.Reader
+	scanner *bufio.Scanner
+
+	first   time.Time
+	started time.Time
+}
+
+// NewPlayer plays the capture file at path, or every capture file of the directory at path
+func NewPlayer(path string, speed float64) (*Player, error) {
+	const op = "internal.capture.NewPlayer"
+
+	if speed < 0 {
+		return nil, fmt.Errorf("%s: speed must not be negative", op)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	files := []string{path}
+
+	if info.IsDir() {
+		if files, err = Files(path); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		if len(files) == 0 {
+			return nil, fmt.Errorf("%s: no capture files in %s", op, path)
+		}
+	}
+
+	return &Player{files: files, speed: speed}, nil
+}
+
+// Next blocks until the next record is due and returns it, io.EOF ends the capture
+func (p *Player) Next() (Record, error) {
+	const op = "internal.capture.Next"
+
+	rec, err := p.read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return Record{}, io.EOF
+		}
+
+		return Record{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	p.wait(rec.Time())
+
+	return rec, nil
+}
+
+func (p *Player) read() (Record, error) {
+	for {
+		if p.scanner == nil {
+			if err := p.openNext(); err != nil {
+				return Record{}, err
+			}
+		}
+
+		if p.scanner.Scan() {
+			var rec Record
+
+			if err := json.Unmarshal(p.scanner.Bytes(), &rec); err != nil {
+				return Record{}, err
+			}
+
+			return rec, nil
+		}
+
+		err := p.scanner.Err()
+
+		p.closeFile()
+
+		// a capture cut by a crash ends mid gzip block, keep what was readable
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return Record{}, err
+		}
+	}
+}
+
+func (p *Player) openNext() error {
+	if len(p.files) == 0 {
+		return io.EOF
+	}
+
+	file, err := os.Open(p.files[0])
+	if err != nil {
+		return err
+	}
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+
+		return fmt.Errorf("%s: %w", p.files[0], err)
+	}
+
+	p.files = p.files[1:]
+	p.file = file
+	p.gz = gz
+	p.scanner = bufio.NewScanner(gz)
+	p.scanner.Buffer(make([]byte, 0, 64<<10), maxLineBytes)
+
+	return nil
+}
+
+func (p *Player) closeFile() {
+	if p.file == nil {
+		return
+	}
+
+	p.gz.Close()
+	p.file.Close()
+
+	p.file = nil
+	p.gz = nil
+	p.scanner = nil
+}
+
+// wait sleeps until receivedAt, scaled by speed, has passed since the first record was played
+func (p *Player) wait(receivedAt time.Time) {
+	if p.first.IsZero() {
+		p.first = receivedAt
+		p.started = time.Now()
+
+		return
+	}
+
+	if p.speed == 0 {
+		return
+	}
+
+	due := p.started.Add(time.Duration(float64(receivedAt.Sub(p.first)) / p.speed))
+
+	if d := time.Until(due); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// Close releases the file being played
+func (p *Player) Close() error {
+	p.closeFile()
+	p.files = nil
+
+	return nil
+}
@@ -0,0 +1,288 @@
+package capture
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/aggregate-binance-depth/internal/metrics"
+)
+
+const (
+	recordBuffer = 4096
+	// flushInterval bounds how much of the capture is lost if the process dies
+	flushInterval = time.Second
+)
+
+// Options bound the capture files, a zero value disables that limit
+type Options struct {
+	Dir string
+	// MaxFileBytes and MaxFileAge rotate the current file, bytes are counted before compression
+	MaxFileBytes int64
+	MaxFileAge   time.Duration
+	// RetentionBytes and RetentionAge delete the oldest rotated files, bytes are on disk
+	RetentionBytes int64
+	RetentionAge   time.Duration
+}
+
+type pending struct {
+	source     string
+	receivedAt time.Time
+	frame      []byte
+}
+
+// Recorder tees upstream frames to rotating gzip files, frames are written by a
+// background goroutine so a slow disk never blocks the upstream read
+type Recorder struct {
+	log     *slog.Logger
+	opts    Options
+	records chan pending
+	done    chan struct{}
+	mu      sync.RWMutex
+	closed  bool
+
+	file     *os.File
+	gz       *gzip.Writer
+	buf      *bufio.Writer
+	openedAt time.Time
+	written  int64
+}
+
+func NewRecorder(l *slog.Logger, opts Options) (*Recorder, error) {
+	const op = "internal.capture.NewRecorder"
+
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	r := &Recorder{
+		log:     l,
+		opts:    opts,
+		records: make(chan pending, recordBuffer),
+		done:    make(chan struct{}),
+	}
+
+	go r.run()
+
+	return r, nil
+}
+
+// Record queues a copy of frame, the frame is dropped when the queue is full
+func (r *Recorder) Record(source string, receivedAt time.Time, frame []byte) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.closed {
+		return
+	}
+
+	select {
+	case r.records <- pending{source: source, receivedAt: receivedAt, frame: slices.Clone(frame)}:
+	default:
+		metrics.RecorderDroppedFrames.Inc()
+	}
+}
+
+// Close writes the queued frames and closes the current file
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+
+	if !r.closed {
+		r.closed = true
+		close(r.records)
+	}
+
+	r.mu.Unlock()
+
+	<-r.done
+
+	return nil
+}
+
+func (r *Recorder) run() {
+	const op = "internal.capture.run"
+
+	logger := r.log.With(slog.String("op", op))
+
+	defer close(r.done)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case p, ok := <-r.records:
+			if !ok {
+				if err := r.closeFile(); err != nil {
+					logger.Error("error with close capture file", slog.String("error", err.Error()))
+				}
+
+				return
+			}
+
+			if err := r.write(p); err != nil {
+				logger.Error("error with write record", slog.String("error", err.Error()))
+			}
+		case <-ticker.C:
+			if err := r.flush(); err != nil {
+				logger.Error("error with flush capture file", slog.String("error", err.Error()))
+			}
+		}
+	}
+}
+
+func (r *Recorder) write(p pending) error {
+	line, err := json.Marshal(Record{ReceivedAt: p.receivedAt.UnixNano(), Source: p.source, Frame: p.frame})
+	if err != nil {
+		metrics.DecodeErrors.WithLabelValues("recorder").Inc()
+
+		return err
+	}
+
+	if r.shouldRotate(p.receivedAt) {
+		if err := r.rotate(p.receivedAt); err != nil {
+			return err
+		}
+	}
+
+	line = append(line, '\n')
+
+	if _, err := r.buf.Write(line); err != nil {
+		return err
+	}
+
+	r.written += int64(len(line))
+
+	return nil
+}
+
+// flush pushes buffered records through gzip to the file so it is readable up to them
+func (r *Recorder) flush() error {
+	if r.file == nil {
+		return nil
+	}
+
+	if err := r.buf.Flush(); err != nil {
+		return err
+	}
+
+	return r.gz.Flush()
+}
+
+func (r *Recorder) shouldRotate(now time.Time) bool {
+	if r.file == nil {
+		return true
+	}
+
+	if r.opts.MaxFileBytes > 0 && r.written >= r.opts.MaxFileBytes {
+		return true
+	}
+
+	return r.opts.MaxFileAge > 0 && now.Sub(r.openedAt) >= r.opts.MaxFileAge
+}
+
+func (r *Recorder) rotate(now time.Time) error {
+	if err := r.closeFile(); err != nil {
+		return err
+	}
+
+	file, err := os.Create(fileName(r.opts.Dir, now))
+	if err != nil {
+		return err
+	}
+
+	r.file = file
+	r.gz = gzip.NewWriter(file)
+	r.buf = bufio.NewWriter(r.gz)
+	r.openedAt = now
+	r.written = 0
+
+	return r.prune(now)
+}
+
+func (r *Recorder) closeFile() error {
+	if r.file == nil {
+		return nil
+	}
+
+	err := r.buf.Flush()
+
+	if closeErr := r.gz.Close(); err == nil {
+		err = closeErr
+	}
+
+	if closeErr := r.file.Close(); err == nil {
+		err = closeErr
+	}
+
+	r.file = nil
+
+	return err
+}
+
+// prune deletes rotated files past retention, newest first so the size budget keeps recent captures
+func (r *Recorder) prune(now time.Time) error {
+	files, err := Files(r.opts.Dir)
+	if err != nil {
+		return err
+	}
+
+	current := r.file.Name()
+
+	var total int64
+
+	for i := len(files) - 1; i >= 0; i-- {
+		path := files[i]
+
+		if path == current {
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+
+		total += info.Size()
+
+		expired := r.opts.RetentionAge > 0 && now.Sub(info.ModTime()) > r.opts.RetentionAge
+		oversized := r.opts.RetentionBytes > 0 && total > r.opts.RetentionBytes
+
+		if expired || oversized {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Files lists the capture files of dir oldest first
+func Files(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]string, 0, len(entries))
+
+	for _, entry := range entries {
+		if entry.IsDir() || !isCaptureFile(entry.Name()) {
+			continue
+		}
+
+		res = append(res, filepath.Join(dir, entry.Name()))
+	}
+
+	slices.Sort(res)
+
+	return res, nil
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"flag"
 	"os"
+	"time"
 
 	"github.com/ilyakaznacheev/cleanenv"
 )
@@ -10,28 +11,206 @@ import (
 type Config struct {
 	Env     string  `yaml:"env" env-required:"true"`
 	Binance Binance `yaml:"binance" env-required:"true"`
-	Wss     Wss     `yaml:"ws"`
+	Okx     Okx     `yaml:"okx"`
+	// Consolidated symbols merge the books of several venues into one NBBO:<symbol>
+	Consolidated []Consolidated `yaml:"consolidated"`
+	Instruments  Instruments    `yaml:"instruments"`
+	Bars         Bars           `yaml:"bars"`
+	Snapshots    Snapshots      `yaml:"snapshots"`
+	Wss          Wss            `yaml:"ws"`
+	Grpc         Grpc           `yaml:"grpc"`
+	Tracing      Tracing        `yaml:"tracing"`
+	Replay       Replay         `yaml:"replay"`
+}
+
+// Replay feeds a capture instead of Binance when Path is set, see the -replay flags
+type Replay struct {
+	// Path is a capture file or a directory of them
+	Path string `yaml:"path"`
+	// Speed 1 is the original speed, N is N times faster and 0 as fast as possible
+	Speed float64 `yaml:"speed" env-default:"1"`
 }
 
 type Binance struct {
-	Depth BinanceDepth
+	// Env is spot, spotTestnet, dataStream or custom
+	Env string `yaml:"env" env-default:"dataStream"`
+	// WsHosts override the env's hosts and are tried in order when one is unreachable,
+	// e.g. ["ws://127.0.0.1:9443"], custom requires them and RestBaseUrl
+	WsHosts     []string `yaml:"wsHosts"`
+	RestBaseUrl string   `yaml:"restBaseUrl"`
+	// USDM and COINM override the futures hosts, in the custom env they default to the ones above
+	USDM        Endpoints `yaml:"usdm"`
+	COINM       Endpoints `yaml:"coinm"`
+	Depth       BinanceDepth
+	Compression Compression `yaml:"compression"`
+	Recorder    Recorder    `yaml:"recorder"`
+	// Keepalive pings the upstream every interval and reconnects when no pong came for two, zero disables it
+	Keepalive time.Duration `yaml:"keepalive" env-default:"30s"`
+}
+
+// Okx streams the books of Instruments next to Binance, books are keyed OKX:<instId>
+type Okx struct {
+	// WsHosts override the public hosts and are tried in order, e.g. ["ws://127.0.0.1:9444"]
+	WsHosts     []string `yaml:"wsHosts"`
+	RestBaseUrl string   `yaml:"restBaseUrl"`
+	// Instruments are instrument ids, e.g. BTC-USDT or BTC-USDT-SWAP, none disables OKX
+	Instruments []string    `yaml:"instruments"`
+	Compression Compression `yaml:"compression"`
+}
+
+// Instruments is where the metadata of tracked instruments comes from: exchange, file or none
+type Instruments struct {
+	Source string `yaml:"source" env-default:"exchange"`
+	// Path of a YAML file with an instruments list when Source is file
+	Path string `yaml:"path"`
+}
+
+// Bars of mid, spread and top of book sizes per symbol, History closed bars of each interval are kept
+type Bars struct {
+	// Intervals default to 1s and 1m
+	Intervals []time.Duration `yaml:"intervals"`
+	History   int             `yaml:"history" env-default:"1000"`
+}
+
+// Snapshots persist every book to Path and restore them at startup marked stale until the
+// upstream resynchronises, nothing is restored or saved when replaying a capture
+type Snapshots struct {
+	Enabled  bool          `yaml:"enabled"`
+	Path     string        `yaml:"path" env-default:"./state/books.json"`
+	Interval time.Duration `yaml:"interval" env-default:"10s"`
+	// MaxAge skips restoring an older checkpoint, zero restores any
+	MaxAge time.Duration `yaml:"maxAge" env-default:"1h"`
+}
+
+type Consolidated struct {
+	Symbol string `yaml:"symbol"`
+	// Books are the merged book keys, e.g. ["btcusdt", "okx:BTC-USDT"]
+	Books []string `yaml:"books"`
+}
+
+// Recorder captures raw upstream frames for replay, zero limits are unlimited
+type Recorder struct {
+	Enabled        bool          `yaml:"enabled"`
+	Dir            string        `yaml:"dir" env-default:"./captures"`
+	MaxFileBytes   int64         `yaml:"maxFileBytes" env-default:"67108864"`
+	MaxFileAge     time.Duration `yaml:"maxFileAge" env-default:"1h"`
+	RetentionBytes int64         `yaml:"retentionBytes" env-default:"1073741824"`
+	RetentionAge   time.Duration `yaml:"retentionAge" env-default:"72h"`
 }
 type Wss struct {
 	Port int `yaml:"port"`
+	// Address overrides Port, e.g. "0.0.0.0:8080", empty keeps localhost:Port
+	Address     string      `yaml:"address"`
+	Compression Compression `yaml:"compression"`
+	Auth        Auth        `yaml:"auth"`
+	TLS         TLS         `yaml:"tls"`
+	Limits      Limits      `yaml:"limits"`
+	// LiquidityBps adds the liquidity within each band of bps around the mid to every update, e.g. [10, 50].
+	// It is deliberately server wide: the bands are computed once per book change and sent to every
+	// client of every transport, so keep them few. Clients wanting their own bands query
+	// GET /depth/{symbol}/liquidity instead.
+	LiquidityBps []float64 `yaml:"liquidityBps"`
+}
+
+// Limits of downstream clients, zero means unlimited
+type Limits struct {
+	MaxConnections       int `yaml:"maxConnections"`
+	MaxConnectionsPerIP  int `yaml:"maxConnectionsPerIp"`
+	MaxConnectionsPerKey int `yaml:"maxConnectionsPerKey"`
+	// ControlRate is inbound control messages per second with bursts of ControlBurst
+	ControlRate      float64 `yaml:"controlRate"`
+	ControlBurst     int     `yaml:"controlBurst"`
+	MaxSubscriptions int     `yaml:"maxSubscriptions"`
+}
+
+// TLS files are reloaded on change, ClientCAFile enables mutual TLS
+type TLS struct {
+	Enabled      bool   `yaml:"enabled"`
+	CertFile     string `yaml:"certFile"`
+	KeyFile      string `yaml:"keyFile"`
+	ClientCAFile string `yaml:"clientCAFile"`
+}
+
+// Auth of downstream clients, mode is one of none, apiKey, hmac, jwt
+type Auth struct {
+	Mode           string   `yaml:"mode" env-default:"none"`
+	APIKeys        []APIKey `yaml:"apiKeys"`
+	HMACSecret     string   `yaml:"hmacSecret" env:"WS_AUTH_HMAC_SECRET"`
+	JWKSPath       string   `yaml:"jwksPath"`
+	JWTIssuer      string   `yaml:"jwtIssuer"`
+	JWTAudience    string   `yaml:"jwtAudience"`
+	AllowedOrigins []string `yaml:"allowedOrigins"`
+}
+
+type APIKey struct {
+	Name    string   `yaml:"name"`
+	Key     string   `yaml:"key"`
+	Symbols []string `yaml:"symbols"`
+}
+
+// Compression is permessage-deflate, level is compress/flate level from -2 to 9
+type Compression struct {
+	Enabled bool `yaml:"enabled"`
+	Level   int  `yaml:"level" env-default:"1"`
+}
+
+type Grpc struct {
+	Port int `yaml:"port"`
+	// Address overrides Port, e.g. "0.0.0.0:9090", empty keeps localhost:Port
+	Address string `yaml:"address"`
+	TLS     TLS    `yaml:"tls"`
+}
+
+type Tracing struct {
+	Enabled bool `yaml:"enabled"`
+	// Exporter is "stdout" or "otlp"
+	Exporter string `yaml:"exporter" env-default:"otlp"`
+	// Endpoint of the OTLP gRPC collector
+	Endpoint    string  `yaml:"endpoint" env-default:"localhost:4317"`
+	SampleRatio float64 `yaml:"sampleRatio" env-default:"0.01"`
+}
+
+type Endpoints struct {
+	WsHosts     []string `yaml:"wsHosts"`
+	RestBaseUrl string   `yaml:"restBaseUrl"`
 }
 
 type BinanceDepth struct {
+	// Symbols are spot symbols
 	Symbols []string `yaml:"symbols"`
+	// Instruments add symbols of any market, spot and futures books of a pair are served side by side
+	Instruments []Instrument `yaml:"instruments"`
+	// Streams are subscribed for every symbol next to depth: bookTicker, aggTrade
+	Streams []string `yaml:"streams"`
+}
+
+type Instrument struct {
+	Symbol string `yaml:"symbol"`
+	// Market is spot, usdm or coinm, empty is spot
+	Market string `yaml:"market"`
 }
 
 func MustLoad() *Config {
+	replayPath := flag.String("replay", "", "play a capture file or directory instead of connecting to Binance")
+	replaySpeed := flag.Float64("replay-speed", -1, "replay speed: 1 original, N times faster, 0 as fast as possible")
+
 	path := fetchConfigPath()
 
 	if path == "" {
 		panic("config is empty")
 	}
 
-	return MustLoadByPath(path)
+	config := MustLoadByPath(path)
+
+	if *replayPath != "" {
+		config.Replay.Path = *replayPath
+	}
+
+	if *replaySpeed >= 0 {
+		config.Replay.Speed = *replaySpeed
+	}
+
+	return config
 }
 
 func MustLoadByPath(path string) *Config {
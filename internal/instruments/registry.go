@@ -0,0 +1,147 @@
+package instruments
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	internalServices "github.com/aggregate-binance-depth/internal/services"
+	"gopkg.in/yaml.v3"
+)
+
+// Instrument is the metadata of a tracked book, zero sizes and empty assets are unknown
+type Instrument struct {
+	// Symbol is the canonical book key the instrument is served under, e.g. BTCUSDT or OKX:BTC-USDT
+	Symbol string `json:"symbol" yaml:"symbol"`
+	Venue  string `json:"venue" yaml:"venue"`
+	// Market is the venue's product type, e.g. spot, usdm, coinm, swap
+	Market string `json:"market" yaml:"market"`
+	// VenueSymbol is the venue's own name of the instrument, e.g. BTCUSDT or BTC-USDT
+	VenueSymbol string  `json:"venueSymbol" yaml:"venueSymbol"`
+	Base        string  `json:"base,omitempty" yaml:"base"`
+	Quote       string  `json:"quote,omitempty" yaml:"quote"`
+	TickSize    float64 `json:"tickSize,omitempty" yaml:"tickSize"`
+	StepSize    float64 `json:"stepSize,omitempty" yaml:"stepSize"`
+}
+
+// Registry holds the tracked instruments in the order they were added, symbols of
+// any case and venue qualified ones such as binance:btcusdt resolve to the same instrument
+type Registry struct {
+	mu          sync.RWMutex
+	instruments map[string]Instrument
+	order       []string
+}
+
+func NewRegistry() *Registry {
+	return &Registry{instruments: make(map[string]Instrument)}
+}
+
+// Canonical is the book key of a symbol as clients or configuration spell it
+func Canonical(symbol string) string {
+	return internalServices.NormalizeBookKey(symbol)
+}
+
+// Add tracks an instrument keyed by its canonical symbol, false means it is tracked already
+func (r *Registry) Add(instrument Instrument) bool {
+	instrument.Symbol = Canonical(instrument.Symbol)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.instruments[instrument.Symbol]; ok {
+		return false
+	}
+
+	r.instruments[instrument.Symbol] = instrument
+	r.order = append(r.order, instrument.Symbol)
+
+	return true
+}
+
+// Describe fills the unknown metadata of a tracked instrument from meta, false means it is not tracked
+func (r *Registry) Describe(meta Instrument) bool {
+	symbol := Canonical(meta.Symbol)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	instrument, ok := r.instruments[symbol]
+	if !ok {
+		return false
+	}
+
+	if instrument.Base == "" {
+		instrument.Base = meta.Base
+	}
+
+	if instrument.Quote == "" {
+		instrument.Quote = meta.Quote
+	}
+
+	if instrument.TickSize == 0 {
+		instrument.TickSize = meta.TickSize
+	}
+
+	if instrument.StepSize == 0 {
+		instrument.StepSize = meta.StepSize
+	}
+
+	r.instruments[symbol] = instrument
+
+	return true
+}
+
+// Get returns the instrument of symbol in any spelling
+func (r *Registry) Get(symbol string) (Instrument, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	instrument, ok := r.instruments[Canonical(symbol)]
+
+	return instrument, ok
+}
+
+// All returns every instrument in the order they were added
+func (r *Registry) All() []Instrument {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	res := make([]Instrument, 0, len(r.order))
+
+	for _, symbol := range r.order {
+		res = append(res, r.instruments[symbol])
+	}
+
+	return res
+}
+
+// Symbols returns the canonical symbols in the order they were added
+func (r *Registry) Symbols() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return append([]string(nil), r.order...)
+}
+
+type file struct {
+	Instruments []Instrument `yaml:"instruments"`
+}
+
+// LoadFile reads a YAML file with an instruments list, symbols are book keys
+// such as btcusdt or okx:BTC-USDT
+func LoadFile(path string) ([]Instrument, error) {
+	const op = "internal.instruments.LoadFile"
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var f file
+
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return f.Instruments, nil
+}
@@ -0,0 +1,137 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+const namespace = "aggregate_binance_depth"
+
+// Registry holds every collector of the service, served on /metrics
+var Registry = prometheus.NewRegistry()
+
+var (
+	UpstreamConnected = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "upstream",
+		Name:      "connected",
+		Help:      "1 when the upstream websocket is connected, per source such as a Binance market or okx.",
+	}, []string{"source"})
+
+	UpstreamMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "upstream",
+		Name:      "messages_total",
+		Help:      "Messages received from upstream per stream.",
+	}, []string{"stream"})
+
+	UpstreamReconnects = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "upstream",
+		Name:      "reconnects_total",
+		Help:      "Upstream connections opened after the first one.",
+	})
+
+	UpstreamResyncs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "upstream",
+		Name:      "resyncs_total",
+		Help:      "Books refetched from a REST snapshot after a gap in the diff stream, per market.",
+	}, []string{"market"})
+
+	DecodeErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "decode_errors_total",
+		Help:      "Messages that failed to decode, stage is upstream for frames, gate for price levels and recorder for captured frames.",
+	}, []string{"stage"})
+
+	GateProcessing = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "gate",
+		Name:      "processing_seconds",
+		Help:      "Time from a decoded upstream message to the update handed to every sink.",
+		Buckets:   prometheus.ExponentialBuckets(0.00001, 4, 10),
+	})
+
+	ConnectedClients = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "downstream",
+		Name:      "connected_clients",
+		Help:      "Connected downstream clients per transport.",
+	}, []string{"transport"})
+
+	SentBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "downstream",
+		Name:      "sent_bytes_total",
+		Help:      "Payload bytes sent to downstream clients per transport.",
+	}, []string{"transport"})
+
+	DroppedMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "downstream",
+		Name:      "dropped_messages_total",
+		Help:      "Messages dropped because a client queue was full.",
+	}, []string{"transport"})
+
+	RecorderDroppedFrames = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "recorder",
+		Name:      "dropped_frames_total",
+		Help:      "Upstream frames not captured because the recorder queue was full.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		UpstreamConnected,
+		UpstreamMessages,
+		UpstreamReconnects,
+		UpstreamResyncs,
+		DecodeErrors,
+		GateProcessing,
+		ConnectedClients,
+		SentBytes,
+		DroppedMessages,
+		RecorderDroppedFrames,
+	)
+}
+
+type lastUpdateSource interface {
+	LastUpdates() map[string]time.Time
+}
+
+// symbolAgeCollector reports how long ago each symbol's book changed, computed at scrape time
+type symbolAgeCollector struct {
+	source lastUpdateSource
+	desc   *prometheus.Desc
+}
+
+// RegisterSymbolAges exposes per-symbol last update age read from source on every scrape
+func RegisterSymbolAges(source lastUpdateSource) error {
+	return Registry.Register(&symbolAgeCollector{
+		source: source,
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "gate", "symbol_last_update_age_seconds"),
+			"Seconds since the symbol's book was last updated.",
+			[]string{"symbol"},
+			nil,
+		),
+	})
+}
+
+func (c *symbolAgeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *symbolAgeCollector) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+
+	for symbol, updatedAt := range c.source.LastUpdates() {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, now.Sub(updatedAt).Seconds(), symbol)
+	}
+}
@@ -0,0 +1,153 @@
+package persistence
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	internalServices "github.com/aggregate-binance-depth/internal/services"
+)
+
+// Options of the checkpoint file, a zero Interval only saves on Shutdown
+type Options struct {
+	Path     string
+	Interval time.Duration
+}
+
+type checkpointSource interface {
+	Checkpoint() internalServices.Checkpoint
+}
+
+// Store writes checkpoints of the books to one JSON file, the file is replaced
+// atomically so a crash mid write leaves the previous checkpoint in place
+type Store struct {
+	log       *slog.Logger
+	opts      Options
+	source    checkpointSource
+	mu        sync.Mutex
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewStore(l *slog.Logger, opts Options, source checkpointSource) (*Store, error) {
+	const op = "internal.persistence.NewStore"
+
+	if err := os.MkdirAll(filepath.Dir(opts.Path), 0o755); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &Store{
+		log:    l,
+		opts:   opts,
+		source: source,
+		done:   make(chan struct{}),
+	}, nil
+}
+
+// Load reads the checkpoint at path, a missing file is an error matching fs.ErrNotExist
+func Load(path string) (internalServices.Checkpoint, error) {
+	const op = "internal.persistence.Load"
+
+	var cp internalServices.Checkpoint
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cp, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return cp, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return cp, nil
+}
+
+// Serve saves a checkpoint every interval until Shutdown
+func (s *Store) Serve() {
+	const op = "internal.persistence.Serve"
+
+	logger := s.log.With(slog.String("op", op))
+
+	if s.opts.Interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if err := s.Save(); err != nil {
+				logger.Error("error with Save", slog.String("error", err.Error()))
+			}
+		}
+	}
+}
+
+// Shutdown stops Serve and saves a last checkpoint
+func (s *Store) Shutdown() {
+	const op = "internal.persistence.Shutdown"
+
+	logger := s.log.With(slog.String("op", op))
+
+	s.closeOnce.Do(func() {
+		close(s.done)
+
+		if err := s.Save(); err != nil {
+			logger.Error("error with Save", slog.String("error", err.Error()))
+
+			return
+		}
+
+		logger.Info("checkpoint saved", slog.String("path", s.opts.Path))
+	})
+}
+
+// Save writes the current checkpoint next to the file and renames it over the file
+func (s *Store) Save() error {
+	const op = "internal.persistence.Save"
+
+	data, err := json.Marshal(s.source.Checkpoint())
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.opts.Path), filepath.Base(s.opts.Path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.opts.Path); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
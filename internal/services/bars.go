@@ -0,0 +1,424 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBarIntervals are used when no interval is configured
+var DefaultBarIntervals = []time.Duration{time.Second, time.Minute}
+
+const DefaultBarHistory = 1000
+
+var ErrUnknownInterval = errors.New("interval is not configured")
+
+// BarValue is the open, high, low and close of a value over a bar and its time weighted average
+type BarValue struct {
+	Open  float64 `json:"open"`
+	High  float64 `json:"high"`
+	Low   float64 `json:"low"`
+	Close float64 `json:"close"`
+	TWAP  float64 `json:"twap"`
+}
+
+// Bar summarises the top of book of a symbol over [Start, End). Updates counts the changes of the
+// quote seen in the bar, a bar without any carries the last quote of the previous one.
+type Bar struct {
+	Symbol   symbol    `json:"symbol"`
+	Interval string    `json:"interval"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Mid      BarValue  `json:"mid"`
+	Spread   BarValue  `json:"spread"`
+	BidQty   BarValue  `json:"bidQty"`
+	AskQty   BarValue  `json:"askQty"`
+	Updates  int       `json:"updates"`
+	// Stale marks a bar whose quotes were restored ones not resynchronised yet or whose upstream
+	// was down when it closed, its values may be carried over from before
+	Stale bool `json:"stale,omitempty"`
+}
+
+// BarWriter is a sink of closed bars
+type BarWriter interface {
+	WriteBar(ctx context.Context, bar Bar) error
+}
+
+// quote is the top of book values bars are made of
+type quote struct {
+	mid, spread, bidQty, askQty float64
+}
+
+func quoteOf(depth DepthWriterRequest) quote {
+	return quote{
+		mid:    (depth.Bid + depth.Ask) / 2,
+		spread: depth.Ask - depth.Bid,
+		bidQty: depth.BidQty,
+		askQty: depth.AskQty,
+	}
+}
+
+type barKey struct {
+	symbol   symbol
+	interval time.Duration
+}
+
+// barSeries is the open bar of a symbol and interval with the closed ones before it
+type barSeries struct {
+	current Bar
+	open    bool
+	last    quote
+	lastAt  time.Time
+	// weighted sums each quote times how long it was current, over weightedFor
+	weighted    quote
+	weightedFor time.Duration
+	closed      barRing
+}
+
+// Bars is a DepthWriter turning published tops of book into bars of every interval,
+// bars are aligned to the interval and closed ones are kept in a ring buffer per symbol
+type Bars struct {
+	log       *slog.Logger
+	intervals []time.Duration
+	history   int
+	writer    BarWriter
+	// connected reports whether a symbol's upstream is up, see SetConnected
+	connected func(symbol string) bool
+	series    map[barKey]*barSeries
+	mu        sync.Mutex
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewBars keeps history closed bars of each interval per symbol and writes them to writer as they close
+func NewBars(l *slog.Logger, intervals []time.Duration, history int, writer BarWriter) (*Bars, error) {
+	const op = "internal.services.NewBars"
+
+	if len(intervals) == 0 {
+		intervals = DefaultBarIntervals
+	}
+
+	if history <= 0 {
+		history = DefaultBarHistory
+	}
+
+	for _, interval := range intervals {
+		if interval <= 0 {
+			return nil, fmt.Errorf("%s: interval %s is not positive", op, interval)
+		}
+	}
+
+	intervals = slices.Clone(intervals)
+	slices.Sort(intervals)
+
+	return &Bars{
+		log:       l,
+		intervals: slices.Compact(intervals),
+		history:   history,
+		writer:    writer,
+		series:    make(map[barKey]*barSeries),
+		done:      make(chan struct{}),
+	}, nil
+}
+
+// WriteJSON adds a top of book to the open bars of its symbol, books with an empty side are skipped
+func (b *Bars) WriteJSON(ctx context.Context, target DepthWriterRequest) error {
+	if target.Bid <= 0 || target.Ask <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+	q := quoteOf(target)
+
+	b.mu.Lock()
+
+	closed := make([]Bar, 0)
+
+	for _, interval := range b.intervals {
+		s := b.seriesOf(barKey{symbol: target.Symbol, interval: interval})
+		closed = s.rollUntil(now, b.history, closed, b.down(target.Symbol))
+		s.observe(target.Symbol, interval, now, q, target.Stale)
+	}
+
+	b.mu.Unlock()
+
+	return b.write(ctx, closed)
+}
+
+// BulkWriteJSON ignores snapshots, they repeat tops of book the bars have seen
+func (b *Bars) BulkWriteJSON(ctx context.Context, target []DepthWriterRequest) error {
+	return nil
+}
+
+// Serve closes bars at their end even when their symbol is quiet, until Shutdown
+func (b *Bars) Serve() {
+	timer := time.NewTimer(b.untilNextEnd(time.Now()))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-b.done:
+			return
+		case now := <-timer.C:
+			b.mu.Lock()
+
+			closed := make([]Bar, 0)
+
+			for key, s := range b.series {
+				closed = s.rollUntil(now, b.history, closed, b.down(key.symbol))
+			}
+
+			b.mu.Unlock()
+
+			b.write(context.Background(), closed)
+
+			timer.Reset(b.untilNextEnd(time.Now()))
+		}
+	}
+}
+
+// SetConnected marks bars closing while connected reports their symbol's upstream down as stale,
+// without it every upstream counts as up. Call it before Serve.
+func (b *Bars) SetConnected(connected func(symbol string) bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.connected = connected
+}
+
+func (b *Bars) down(s symbol) bool {
+	return b.connected != nil && !b.connected(s)
+}
+
+func (b *Bars) Shutdown() {
+	b.closeOnce.Do(func() {
+		close(b.done)
+	})
+}
+
+// History returns up to limit closed bars of symbol and interval, oldest first
+func (b *Bars) History(s string, interval time.Duration, limit int) ([]Bar, error) {
+	if !slices.Contains(b.intervals, interval) {
+		return nil, ErrUnknownInterval
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	series, ok := b.series[barKey{symbol: NormalizeBookKey(s), interval: interval}]
+	if !ok {
+		return []Bar{}, nil
+	}
+
+	return series.closed.last(limit), nil
+}
+
+// Intervals are the configured intervals, shortest first
+func (b *Bars) Intervals() []time.Duration {
+	return slices.Clone(b.intervals)
+}
+
+func (b *Bars) seriesOf(key barKey) *barSeries {
+	s, ok := b.series[key]
+	if !ok {
+		s = &barSeries{}
+		b.series[key] = s
+	}
+
+	return s
+}
+
+// untilNextEnd is the wait for the earliest interval boundary after now
+func (b *Bars) untilNextEnd(now time.Time) time.Duration {
+	next := now.Truncate(b.intervals[0]).Add(b.intervals[0])
+
+	for _, interval := range b.intervals[1:] {
+		if end := now.Truncate(interval).Add(interval); end.Before(next) {
+			next = end
+		}
+	}
+
+	return next.Sub(now)
+}
+
+func (b *Bars) write(ctx context.Context, closed []Bar) error {
+	const op = "internal.services.Bars.write"
+
+	if b.writer == nil {
+		return nil
+	}
+
+	errs := make([]error, 0)
+
+	for _, bar := range closed {
+		if err := b.writer.WriteBar(ctx, bar); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		b.log.Error("error with WriteBar", slog.String("op", op), slog.String("error", err.Error()))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// rollUntil closes the open bar and the quiet ones after it that end by now, appending them to closed,
+// down marks them stale
+func (s *barSeries) rollUntil(now time.Time, history int, closed []Bar, down bool) []Bar {
+	for s.open && !now.Before(s.current.End) {
+		bar := s.finish()
+		bar.Stale = bar.Stale || down
+		s.closed.push(bar, history)
+		closed = append(closed, bar)
+
+		s.start(bar.Symbol, bar.End.Sub(bar.Start), bar.End)
+	}
+
+	return closed
+}
+
+// observe adds q seen at now, the first quote of a series opens its first bar. A quote equal to
+// the last one, e.g. republished on a trade, is no update.
+func (s *barSeries) observe(sym symbol, interval time.Duration, now time.Time, q quote, stale bool) {
+	changed := !s.open || q != s.last
+
+	if !s.open {
+		s.last = q
+		s.start(sym, interval, now.Truncate(interval))
+		s.lastAt = now
+	}
+
+	s.current.Stale = s.current.Stale || stale
+
+	if !changed {
+		return
+	}
+
+	s.accumulate(now)
+	s.last = q
+
+	s.current.Mid.observe(q.mid)
+	s.current.Spread.observe(q.spread)
+	s.current.BidQty.observe(q.bidQty)
+	s.current.AskQty.observe(q.askQty)
+	s.current.Updates++
+}
+
+// start opens the bar at start with the last quote as its open
+func (s *barSeries) start(sym symbol, interval time.Duration, start time.Time) {
+	s.current = Bar{
+		Symbol:   sym,
+		Interval: FormatInterval(interval),
+		Start:    start,
+		End:      start.Add(interval),
+		Mid:      newBarValue(s.last.mid),
+		Spread:   newBarValue(s.last.spread),
+		BidQty:   newBarValue(s.last.bidQty),
+		AskQty:   newBarValue(s.last.askQty),
+	}
+	s.open = true
+	s.lastAt = start
+	s.weighted = quote{}
+	s.weightedFor = 0
+}
+
+// accumulate weighs the last quote by how long it was current up to t
+func (s *barSeries) accumulate(t time.Time) {
+	d := t.Sub(s.lastAt)
+	if d <= 0 {
+		return
+	}
+
+	w := d.Seconds()
+
+	s.weighted.mid += s.last.mid * w
+	s.weighted.spread += s.last.spread * w
+	s.weighted.bidQty += s.last.bidQty * w
+	s.weighted.askQty += s.last.askQty * w
+	s.weightedFor += d
+	s.lastAt = t
+}
+
+// finish is the open bar with its time weighted averages up to its end
+func (s *barSeries) finish() Bar {
+	s.accumulate(s.current.End)
+
+	bar := s.current
+
+	if w := s.weightedFor.Seconds(); w > 0 {
+		bar.Mid.TWAP = s.weighted.mid / w
+		bar.Spread.TWAP = s.weighted.spread / w
+		bar.BidQty.TWAP = s.weighted.bidQty / w
+		bar.AskQty.TWAP = s.weighted.askQty / w
+	} else {
+		bar.Mid.TWAP = bar.Mid.Close
+		bar.Spread.TWAP = bar.Spread.Close
+		bar.BidQty.TWAP = bar.BidQty.Close
+		bar.AskQty.TWAP = bar.AskQty.Close
+	}
+
+	return bar
+}
+
+func newBarValue(v float64) BarValue {
+	return BarValue{Open: v, High: v, Low: v, Close: v}
+}
+
+func (v *BarValue) observe(x float64) {
+	v.High = max(v.High, x)
+	v.Low = min(v.Low, x)
+	v.Close = x
+}
+
+// barRing holds the latest closed bars, once full the oldest is at next
+type barRing struct {
+	bars []Bar
+	next int
+}
+
+func (r *barRing) push(bar Bar, capacity int) {
+	if len(r.bars) < capacity {
+		r.bars = append(r.bars, bar)
+
+		return
+	}
+
+	r.bars[r.next] = bar
+	r.next = (r.next + 1) % capacity
+}
+
+// last returns up to limit of the latest bars oldest first, limit 0 returns all
+func (r *barRing) last(limit int) []Bar {
+	res := make([]Bar, 0, len(r.bars))
+	res = append(res, r.bars[r.next:]...)
+	res = append(res, r.bars[:r.next]...)
+
+	if limit > 0 && len(res) > limit {
+		res = res[len(res)-limit:]
+	}
+
+	return res
+}
+
+// FormatInterval drops the zero units time.Duration prints, e.g. 1m0s is 1m and 1h0m0s is 1h
+func FormatInterval(interval time.Duration) string {
+	s := interval.String()
+
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+
+	return s
+}
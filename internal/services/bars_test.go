@@ -0,0 +1,66 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBarsCountOnlyChangedQuotes(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	q := quote{mid: 100, spread: 1, bidQty: 2, askQty: 3}
+
+	var s barSeries
+
+	s.observe("BTCUSDT", time.Minute, start.Add(time.Second), q, false)
+	// a trade republishes the same top of book
+	s.observe("BTCUSDT", time.Minute, start.Add(2*time.Second), q, false)
+	s.observe("BTCUSDT", time.Minute, start.Add(3*time.Second), quote{mid: 101, spread: 1, bidQty: 2, askQty: 3}, false)
+
+	closed := s.rollUntil(start.Add(time.Minute), 10, nil, false)
+	if len(closed) != 1 {
+		t.Fatalf("closed = %+v, want one bar", closed)
+	}
+
+	if bar := closed[0]; bar.Updates != 2 || bar.Mid.Close != 101 || bar.Stale {
+		t.Errorf("bar = %+v, want 2 updates closing at 101, not stale", bar)
+	}
+}
+
+func TestBarsMarkStaleQuotesAndDownUpstreams(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	q := quote{mid: 100, spread: 1, bidQty: 2, askQty: 3}
+
+	var s barSeries
+
+	s.observe("BTCUSDT", time.Minute, start, q, true)
+
+	closed := s.rollUntil(start.Add(time.Minute), 10, nil, false)
+	if len(closed) != 1 || !closed[0].Stale {
+		t.Fatalf("closed = %+v, want the bar of a restored quote stale", closed)
+	}
+
+	s.observe("BTCUSDT", time.Minute, start.Add(time.Minute+time.Second), quote{mid: 101}, false)
+
+	b, err := NewBars(nil, []time.Duration{time.Minute}, 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b.SetConnected(func(symbol string) bool { return symbol != "BTCUSDT" })
+
+	// the upstream went down, the quiet bars after the live one carry its last quote
+	closed = s.rollUntil(start.Add(3*time.Minute), 10, nil, b.down("BTCUSDT"))
+	if len(closed) != 2 {
+		t.Fatalf("closed = %+v, want two bars", closed)
+	}
+
+	for _, bar := range closed {
+		if !bar.Stale {
+			t.Errorf("bar = %+v, want stale while the upstream is down", bar)
+		}
+	}
+
+	if b.down("ETHUSDT") {
+		t.Error("ETHUSDT is down, want its upstream connected")
+	}
+}
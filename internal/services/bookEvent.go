@@ -0,0 +1,106 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// BookEventKind selects the payload of a BookEvent
+type BookEventKind int
+
+const (
+	// BookEventDiff merges Bids and Asks into the book, a zero quantity removes a level
+	BookEventDiff BookEventKind = iota
+	// BookEventSnapshot replaces the book with Bids and Asks
+	BookEventSnapshot
+	// BookEventTicker carries the exact best bid and ask in Ticker
+	BookEventTicker
+	// BookEventTrade carries the latest trade in Trade
+	BookEventTrade
+)
+
+// DefaultVenue is the venue whose book keys carry no venue prefix
+const DefaultVenue = "binance"
+
+// BookEvent is a venue agnostic change of a symbol's market data, adapters parse
+// their exchange's payload into it and keep the book in sync before it reaches the gate
+type BookEvent struct {
+	// Trace links the gate spans to the reader's, invalid when the message is not sampled
+	Trace trace.SpanContext
+	// Stream names the upstream stream or channel the event came from
+	Stream string
+	// Venue is the exchange the event came from, e.g. binance or okx
+	Venue string
+	Kind  BookEventKind
+	// Symbol is the book key, see BookKey
+	Symbol symbol
+	Bids   []PriceLevel
+	Asks   []PriceLevel
+	Ticker TopOfBook
+	Trade  Trade
+}
+
+// TopOfBook is the best bid and ask with their sizes
+type TopOfBook struct {
+	Bid    price
+	BidQty quantity
+	Ask    price
+	AskQty quantity
+}
+
+type Trade struct {
+	Price    price
+	Quantity quantity
+	Time     time.Time
+}
+
+// BookKey is the key the gate tracks a venue's symbol by, e.g. OKX:BTC-USDT. Keys of
+// the default venue are the plain symbol, so binance:btcusdt and btcusdt are the same book.
+func BookKey(venue, symbol string) string {
+	symbol = strings.ToUpper(symbol)
+
+	if venue == "" || strings.EqualFold(venue, DefaultVenue) {
+		return symbol
+	}
+
+	return strings.ToUpper(venue) + ":" + symbol
+}
+
+// NormalizeBookKey resolves a symbol requested by a client, optionally venue qualified, to its book key
+func NormalizeBookKey(s string) string {
+	if venue, symbol, ok := strings.Cut(s, ":"); ok && strings.EqualFold(venue, DefaultVenue) {
+		return BookKey(DefaultVenue, symbol)
+	}
+
+	return strings.ToUpper(s)
+}
+
+// ParseLevels converts raw [price, quantity, ...] levels into PriceLevel values,
+// fields after the quantity are ignored
+func ParseLevels(raw [][]string) ([]PriceLevel, error) {
+	res := make([]PriceLevel, 0, len(raw))
+
+	for _, pair := range raw {
+		if len(pair) < 2 {
+			return nil, fmt.Errorf("malformed price level: %v", pair)
+		}
+
+		p, err := strconv.ParseFloat(pair[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse price %q: %w", pair[0], err)
+		}
+
+		q, err := strconv.ParseFloat(pair[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse quantity %q: %w", pair[1], err)
+		}
+
+		res = append(res, PriceLevel{Price: p, Quantity: q})
+	}
+
+	return res, nil
+}
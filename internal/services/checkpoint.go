@@ -0,0 +1,116 @@
+package services
+
+import (
+	"cmp"
+	"slices"
+	"time"
+)
+
+// BookCheckpoint is a book with its last published top of book as persisted across restarts
+type BookCheckpoint struct {
+	Book  BookSnapshot       `json:"book"`
+	Venue string             `json:"venue,omitempty"`
+	Top   DepthWriterRequest `json:"top"`
+}
+
+// Checkpoint is the state of every book at SavedAt, consolidated symbols are rebuilt from their books
+type Checkpoint struct {
+	SavedAt time.Time        `json:"savedAt"`
+	Books   []BookCheckpoint `json:"books"`
+}
+
+// Checkpoint copies every book with all its levels. Restored books not resynchronised yet keep the
+// entry they were restored from, its Book.UpdatedAt tells how old it is whatever SavedAt says.
+func (d *DepthGateService) Checkpoint() Checkpoint {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	res := Checkpoint{SavedAt: time.Now(), Books: make([]BookCheckpoint, 0, len(d.books))}
+
+	for s, book := range d.books {
+		if saved, ok := d.restored[s]; ok && d.stale[s] {
+			res.Books = append(res.Books, saved)
+
+			continue
+		}
+
+		res.Books = append(res.Books, BookCheckpoint{
+			Book:  book.snapshot(s, 0),
+			Venue: d.venues[s],
+			Top:   d.currentDepths[s],
+		})
+	}
+
+	slices.SortFunc(res.Books, func(a, b BookCheckpoint) int {
+		return cmp.Compare(a.Book.Symbol, b.Book.Symbol)
+	})
+
+	return res
+}
+
+// Restore loads the books of a checkpoint marked stale until the upstream sends a fresh snapshot
+// of each, books of symbols no longer tracked are skipped. Call it before Serve, it returns how many
+// books were restored.
+func (d *DepthGateService) Restore(cp Checkpoint) int {
+	restored := func() []symbol {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+
+		res := make([]symbol, 0, len(cp.Books))
+
+		for _, saved := range cp.Books {
+			s := NormalizeBookKey(saved.Book.Symbol)
+
+			if _, ok := d.books[s]; ok || !slices.Contains(d.symbols, s) {
+				continue
+			}
+
+			if _, ok := d.consolidated[s]; ok {
+				continue
+			}
+
+			book := newOrderBook()
+			book.apply(saved.Book.Bids, saved.Book.Asks, saved.Book.UpdatedAt)
+
+			top := saved.Top
+			top.Symbol = s
+			top.Stale = true
+
+			d.books[s] = book
+			d.currentDepths[s] = top
+			d.stale[s] = true
+
+			saved.Book.Symbol = s
+			d.restored[s] = saved
+
+			if saved.Venue != "" {
+				d.venues[s] = saved.Venue
+			}
+
+			res = append(res, s)
+		}
+
+		return res
+	}()
+
+	for _, s := range restored {
+		d.consolidate(s)
+	}
+
+	return len(restored)
+}
+
+// isStale reports whether a book, or any book of a consolidated symbol, is restored and not resynchronised, d.mu must be held
+func (d *DepthGateService) isStale(s symbol) bool {
+	if d.stale[s] {
+		return true
+	}
+
+	for _, member := range d.consolidated[s] {
+		if d.stale[member] {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckpointKeepsRestoredEntriesOfStaleBooks(t *testing.T) {
+	d := NewDepthGateService(nil, []string{"btcusdt", "ethusdt"}, nil, DepthWriters{})
+	updatedAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	restored := d.Restore(Checkpoint{
+		SavedAt: time.Now().Add(-time.Hour),
+		Books: []BookCheckpoint{
+			{Book: BookSnapshot{Symbol: "btcusdt", UpdatedAt: updatedAt, Bids: []PriceLevel{{Price: 100, Quantity: 1}}, Asks: []PriceLevel{{Price: 101, Quantity: 1}}}},
+			{Book: BookSnapshot{Symbol: "ETHUSDT", Bids: []PriceLevel{{Price: 10, Quantity: 1}}, Asks: []PriceLevel{{Price: 11, Quantity: 1}}}},
+		},
+	})
+	if restored != 2 {
+		t.Fatalf("restored = %d, want 2", restored)
+	}
+
+	// only ETHUSDT resyncs
+	d.apply(BookEvent{
+		Kind:   BookEventSnapshot,
+		Symbol: "ETHUSDT",
+		Bids:   []PriceLevel{{Price: 12, Quantity: 2}},
+		Asks:   []PriceLevel{{Price: 13, Quantity: 2}},
+	})
+
+	cp := d.Checkpoint()
+	if len(cp.Books) != 2 {
+		t.Fatalf("books = %+v, want both", cp.Books)
+	}
+
+	// the stale book is saved as it was restored, its age is kept
+	if stale := cp.Books[0].Book; stale.Symbol != "BTCUSDT" || !stale.UpdatedAt.Equal(updatedAt) || stale.Bids[0].Price != 100 {
+		t.Errorf("stale book = %+v, want the restored BTCUSDT updated at %s", stale, updatedAt)
+	}
+
+	if live := cp.Books[1].Book; live.Symbol != "ETHUSDT" || len(live.Bids) != 1 || live.Bids[0].Price != 12 {
+		t.Errorf("live book = %+v, want ETHUSDT with the resynchronised 12", live)
+	}
+}
@@ -0,0 +1,174 @@
+package services
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ConsolidatedPrefix marks synthetic symbols merging the books of several venues, e.g. NBBO:BTC-USDT
+const ConsolidatedPrefix = "NBBO"
+
+// Consolidate tracks the synthetic symbol NBBO:<name> merging books, which are tracked book keys
+// of any venue, and returns its key. It is published whenever one of its books changes, call it before Serve.
+func (d *DepthGateService) Consolidate(name string, books []string) (string, error) {
+	const op = "internal.services.depthGate.Consolidate"
+
+	key := ConsolidatedPrefix + ":" + strings.ToUpper(name)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.consolidated[key]; ok {
+		return "", fmt.Errorf("%s: %s is consolidated twice", op, key)
+	}
+
+	members := make([]symbol, 0, len(books))
+
+	for _, book := range books {
+		member := NormalizeBookKey(book)
+
+		if !slices.Contains(d.symbols, member) {
+			return "", fmt.Errorf("%s: %s merges %s which is not tracked", op, key, book)
+		}
+
+		members = append(members, member)
+	}
+
+	d.consolidated[key] = members
+
+	for _, member := range members {
+		d.members[member] = append(d.members[member], key)
+	}
+
+	d.symbols = append(d.symbols, key)
+
+	return key, nil
+}
+
+// consolidate recomputes the top of book of every synthetic symbol s is part of. The best bid
+// and ask come from the member's current top so exact book tickers are respected, ties keep the
+// first member in configuration order.
+func (d *DepthGateService) consolidate(s symbol) []DepthWriterRequest {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	res := make([]DepthWriterRequest, 0, len(d.members[s]))
+
+	for _, key := range d.members[s] {
+		top := DepthWriterRequest{Symbol: key}
+
+		for _, member := range d.consolidated[key] {
+			depth, ok := d.currentDepths[member]
+			if !ok {
+				continue
+			}
+
+			if depth.Bid > top.Bid {
+				top.Bid, top.BidQty, top.BidVenue = depth.Bid, depth.BidQty, d.venueOf(member)
+			}
+
+			if depth.Ask > 0 && (top.Ask == 0 || depth.Ask < top.Ask) {
+				top.Ask, top.AskQty, top.AskVenue = depth.Ask, depth.AskQty, d.venueOf(member)
+			}
+		}
+
+		top.Stale = d.isStale(key)
+
+		if len(d.liquidityBps) > 0 && top.Bid > 0 && top.Ask > 0 {
+			top.Liquidity = d.liquidity(key, (top.Bid+top.Ask)/2, d.liquidityBps)
+		}
+
+		d.currentDepths[key] = top
+		res = append(res, top)
+	}
+
+	return res
+}
+
+// venueOf names where a book's events come from, the book key until its first event
+func (d *DepthGateService) venueOf(s symbol) string {
+	if venue, ok := d.venues[s]; ok {
+		return venue
+	}
+
+	return s
+}
+
+// mergedSnapshot is the ladder of a synthetic symbol, quantities at a price add up over
+// its books and are split by venue. False means none of the books has data yet, d.mu must be held.
+func (d *DepthGateService) mergedSnapshot(key symbol, levels int) (BookSnapshot, bool) {
+	merged := newOrderBook()
+	bidVenues := make(map[price]map[string]quantity)
+	askVenues := make(map[price]map[string]quantity)
+	found := false
+
+	for _, member := range d.consolidated[key] {
+		book, ok := d.books[member]
+		if !ok {
+			continue
+		}
+
+		found = true
+		venue := d.venueOf(member)
+
+		mergeSide(merged.bids, bidVenues, book.bids, venue)
+		mergeSide(merged.asks, askVenues, book.asks, venue)
+
+		if book.updatedAt.After(merged.updatedAt) {
+			merged.updatedAt = book.updatedAt
+		}
+	}
+
+	if !found {
+		return BookSnapshot{}, false
+	}
+
+	snapshot := merged.snapshot(key, levels)
+	snapshot.Stale = d.isStale(key)
+
+	splitByVenue(snapshot.Bids, bidVenues)
+	splitByVenue(snapshot.Asks, askVenues)
+
+	return snapshot, true
+}
+
+// mergedUpdatedAt is the latest change of any book of a synthetic symbol, d.mu must be held
+func (d *DepthGateService) mergedUpdatedAt(key symbol) (time.Time, bool) {
+	var res time.Time
+
+	found := false
+
+	for _, member := range d.consolidated[key] {
+		if book, ok := d.books[member]; ok {
+			found = true
+
+			if book.updatedAt.After(res) {
+				res = book.updatedAt
+			}
+		}
+	}
+
+	return res, found
+}
+
+func mergeSide(merged *bookSide, venues map[price]map[string]quantity, side *bookSide, venue string) {
+	side.each(func(p price, q quantity) bool {
+		merged.set(p, merged.get(p)+q)
+
+		if venues[p] == nil {
+			venues[p] = make(map[string]quantity)
+		}
+
+		venues[p][venue] += q
+
+		return true
+	})
+}
+
+func splitByVenue(levels []PriceLevel, venues map[price]map[string]quantity) {
+	for i := range levels {
+		levels[i].Venues = venues[levels[i].Price]
+	}
+}
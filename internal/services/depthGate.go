@@ -1,84 +1,177 @@
 package services
 
 import (
+	"context"
+	"errors"
+	"io"
 	"log/slog"
-	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aggregate-binance-depth/internal/metrics"
+	"github.com/aggregate-binance-depth/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+var tracer = tracing.Tracer("github.com/aggregate-binance-depth/internal/services")
+
 type symbol = string
 type price = float64
 
 type currentDepths = map[symbol]DepthWriterRequest
 
+const (
+	SymbolStatusPending = "pending"
+	SymbolStatusLive    = "live"
+	// SymbolStatusStale is a book restored at startup the upstream has not resynchronised yet
+	SymbolStatusStale = "stale"
+)
+
 type DepthGateService struct {
 	log           *slog.Logger
+	symbols       []symbol
 	currentDepths currentDepths
-	mu            sync.Mutex
-	reader        DepthReader
-	writer        DepthWriter
-	closed        bool
-}
-
-type DepthReaderResponse struct {
-	Stream string
-	Data   struct {
-		Symbol symbol
-		Bids   [][]string
-		Asks   [][]string
-	}
+	books         map[symbol]*orderBook
+	// tickers are symbols whose best bid and ask come from a book ticker instead of the book
+	tickers map[symbol]bool
+	// stale are books restored from a checkpoint until their first upstream snapshot
+	stale map[symbol]bool
+	// restored are the checkpoint entries of stale books, saved again as is until the book resyncs
+	restored map[symbol]BookCheckpoint
+	// venues are where each book's events come from
+	venues map[symbol]string
+	// consolidated maps synthetic symbols to the books they merge, members maps a book to its synthetic symbols
+	consolidated map[symbol][]symbol
+	members      map[symbol][]symbol
+	groupings    map[groupingKey]*grouping
+	// tickSizes are the price increments of books, see SetTickSize
+	tickSizes map[symbol]price
+	// liquidityBps are the bands published updates carry liquidity within, none leaves it out
+	liquidityBps []float64
+	mu           sync.Mutex
+	reader       DepthReader
+	writer       DepthWriter
+	closed       atomic.Bool
+}
+
+// SymbolStatus describes a tracked symbol and whether its book has received data
+type SymbolStatus struct {
+	Symbol    symbol    `json:"symbol"`
+	Status    string    `json:"status"`
+	UpdatedAt time.Time `json:"updatedAt"`
 }
 
 type DepthWriterRequest struct {
-	Symbol symbol `json:"symbol"`
-	Bid    price  `json:"bid"`
-	Ask    price  `json:"ask"`
+	Symbol symbol   `json:"symbol"`
+	Bid    price    `json:"bid"`
+	Ask    price    `json:"ask"`
+	BidQty quantity `json:"bidQty"`
+	AskQty quantity `json:"askQty"`
+	// LastPrice and LastQty are of the latest trade, zero until a trade stream delivers one
+	LastPrice price    `json:"lastPrice,omitempty"`
+	LastQty   quantity `json:"lastQty,omitempty"`
+	// BidVenue and AskVenue are where the best bid and ask of a consolidated symbol are
+	BidVenue string `json:"bidVenue,omitempty"`
+	AskVenue string `json:"askVenue,omitempty"`
+	// Liquidity is within each configured band of the mid price, see SetLiquidityBands
+	Liquidity []Liquidity `json:"liquidity,omitempty"`
+	// Stale is a book restored at startup the upstream has not resynchronised yet
+	Stale bool `json:"stale,omitempty"`
 }
 
 type DepthReader interface {
-	ReadJSON(target *DepthReaderResponse) error
+	ReadJSON(target *BookEvent) error
 }
 
 type DepthWriter interface {
-	WriteJSON(target DepthWriterRequest) error
-	BulkWriteJSON(target []DepthWriterRequest) error
+	WriteJSON(ctx context.Context, target DepthWriterRequest) error
+	BulkWriteJSON(ctx context.Context, target []DepthWriterRequest) error
 }
 
-func NewDepthGateService(l *slog.Logger, depthReader DepthReader, depthWriter DepthWriter) *DepthGateService {
+func NewDepthGateService(l *slog.Logger, symbols []string, depthReader DepthReader, depthWriter DepthWriter) *DepthGateService {
+	tracked := make([]symbol, 0, len(symbols))
+
+	for _, s := range symbols {
+		tracked = append(tracked, NormalizeBookKey(s))
+	}
+
 	return &DepthGateService{
 		reader:        depthReader,
 		writer:        depthWriter,
 		log:           l,
+		symbols:       tracked,
 		currentDepths: make(currentDepths),
+		books:         make(map[symbol]*orderBook),
+		tickers:       make(map[symbol]bool),
+		stale:         make(map[symbol]bool),
+		restored:      make(map[symbol]BookCheckpoint),
+		venues:        make(map[symbol]string),
+		consolidated:  make(map[symbol][]symbol),
+		members:       make(map[symbol][]symbol),
+		groupings:     make(map[groupingKey]*grouping),
+		tickSizes:     make(map[symbol]price),
 	}
 }
 
-func (d *DepthGateService) convertDepthRToW(data DepthReaderResponse) (DepthWriterRequest, error) {
-	const op = "internal.services.depthGate.ConvertDepthRToW"
-
-	logger := d.log.With(slog.String("op", op))
+// apply updates the symbol's state with an event of any kind and returns the resulting top of book
+func (d *DepthGateService) apply(e BookEvent) DepthWriterRequest {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 
-	ask := "0"
-	if len(data.Data.Asks) > 0 && len(data.Data.Asks[0]) > 0 {
-		ask = data.Data.Asks[0][0]
+	if e.Venue != "" {
+		d.venues[e.Symbol] = e.Venue
 	}
 
-	bid := "0"
-	if len(data.Data.Bids) > 0 && len(data.Data.Bids[0]) > 0 {
-		bid = data.Data.Bids[0][0]
-	}
+	res := d.currentDepths[e.Symbol]
+	res.Symbol = e.Symbol
+
+	switch e.Kind {
+	case BookEventTicker:
+		// the exact best bid and ask, from then on the book no longer sets them
+		d.tickers[e.Symbol] = true
+		res.Bid, res.BidQty, res.Ask, res.AskQty = e.Ticker.Bid, e.Ticker.BidQty, e.Ticker.Ask, e.Ticker.AskQty
+	case BookEventTrade:
+		res.LastPrice, res.LastQty = e.Trade.Price, e.Trade.Quantity
+	default:
+		book, ok := d.books[e.Symbol]
+		if !ok || e.Kind == BookEventSnapshot {
+			replaced := book
+
+			book = newOrderBook()
+			d.books[e.Symbol] = book
+
+			// the version goes on so groupings of the replaced book are stale
+			if replaced != nil {
+				book.version = replaced.version
+			}
 
-	askPrice, err := strconv.ParseFloat(ask, 64)
-	if err != nil {
-		logger.Error("error with strconv.ParseFloat ask", slog.String("error", err.Error()))
-	}
+			delete(d.stale, e.Symbol)
+			delete(d.restored, e.Symbol)
+		}
+
+		book.apply(e.Bids, e.Asks, time.Now())
+
+		if e.Kind == BookEventSnapshot {
+			book.synced = true
+		}
+
+		if !d.tickers[e.Symbol] {
+			res.Bid, res.BidQty = book.bestBidLevel()
+			res.Ask, res.AskQty = book.bestAskLevel()
+		}
 
-	bidsPrice, err := strconv.ParseFloat(bid, 64)
-	if err != nil {
-		logger.Error("error with strconv.ParseFloat bids", slog.String("error", err.Error()))
+		res.Liquidity = nil
+
+		if len(d.liquidityBps) > 0 && res.Bid > 0 && res.Ask > 0 {
+			res.Liquidity = d.liquidity(e.Symbol, (res.Bid+res.Ask)/2, d.liquidityBps)
+		}
 	}
 
-	return DepthWriterRequest{Symbol: data.Data.Symbol, Bid: bidsPrice, Ask: askPrice}, nil
+	res.Stale = d.stale[e.Symbol]
+	d.currentDepths[e.Symbol] = res
+
+	return res
 }
 
 func (d *DepthGateService) Serve() {
@@ -86,38 +179,51 @@ func (d *DepthGateService) Serve() {
 
 	logger := d.log.With(slog.String("op", op))
 
-	if d.closed {
+	if d.closed.Load() {
 		logger.Error("server already closed")
 
 		return
 	}
 
-	var readerResponse DepthReaderResponse
+	var event BookEvent
 	var writerRequest DepthWriterRequest
 	var err error
 
-	for !(d.closed) {
+	for !d.closed.Load() {
 		func() {
-			if err = d.reader.ReadJSON(&readerResponse); err != nil {
+			if err = d.reader.ReadJSON(&event); err != nil {
+				if errors.Is(err, io.EOF) {
+					return
+				}
+
 				logger.Error("error with ReadJSON", slog.String("error", err.Error()))
 				return
 			}
 
-			writerRequest, err = d.convertDepthRToW(readerResponse)
+			start := time.Now()
 
-			if err != nil {
-				logger.Error("error with convertDepthRToW", slog.String("error", err.Error()))
-				return
-			}
+			ctx, span := tracer.Start(tracing.ContextWith(event.Trace), "gate.apply")
+			defer span.End()
 
-			d.mu.Lock()
-			defer d.mu.Unlock()
+			writerRequest = d.apply(event)
 
-			d.currentDepths[writerRequest.Symbol] = writerRequest
+			span.SetAttributes(attribute.String("symbol", writerRequest.Symbol))
 
-			d.writer.WriteJSON(writerRequest)
+			d.writer.WriteJSON(ctx, writerRequest)
+
+			for _, consolidated := range d.consolidate(writerRequest.Symbol) {
+				d.writer.WriteJSON(ctx, consolidated)
+			}
+
+			metrics.GateProcessing.Observe(time.Since(start).Seconds())
 		}()
 
+		// a finite reader such as a replay has nothing more to deliver
+		if errors.Is(err, io.EOF) {
+			logger.Info("reader finished")
+			break
+		}
+
 		logger.Debug("writerRequest", slog.Any("writerRequest", writerRequest))
 	}
 
@@ -131,16 +237,13 @@ func (d *DepthGateService) Shutdown() {
 
 	logger.Info("Shutting down...")
 
-	d.closed = true
+	d.closed.Store(true)
 
 	logger.Info("Shutting success")
 }
 
-func (d *DepthGateService) WriteCurrentDeps() error {
-	const op = "internal.services.depthGate.WriteCurrentDeps"
-
-	logger := d.log.With(slog.String("op", op))
-
+// CurrentDepths returns the latest top of book for every symbol that received data
+func (d *DepthGateService) CurrentDepths() []DepthWriterRequest {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
@@ -150,11 +253,113 @@ func (d *DepthGateService) WriteCurrentDeps() error {
 		values = append(values, value)
 	}
 
-	if err := d.writer.BulkWriteJSON(values); err != nil {
-		logger.Error("error with WriteJSON", slog.String("error", err.Error()))
+	return values
+}
+
+// Book returns up to levels price levels per side for the symbol, levels <= 0 means the whole book
+func (d *DepthGateService) Book(s string, levels int) (BookSnapshot, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.snapshot(NormalizeBookKey(s), levels)
+}
+
+// snapshot is the book of a symbol or the merged one of a consolidated symbol, d.mu must be held
+func (d *DepthGateService) snapshot(s symbol, levels int) (BookSnapshot, bool) {
+	if _, ok := d.consolidated[s]; ok {
+		return d.mergedSnapshot(s, levels)
+	}
+
+	book, ok := d.books[s]
+	if !ok {
+		return BookSnapshot{}, false
+	}
+
+	res := book.snapshot(s, levels)
+	res.Stale = d.stale[s]
+
+	return res, true
+}
+
+// Books returns snapshots of every book limited to levels per side
+func (d *DepthGateService) Books(levels int) []BookSnapshot {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	res := make([]BookSnapshot, 0, len(d.books))
+
+	for s, book := range d.books {
+		snapshot := book.snapshot(s, levels)
+		snapshot.Stale = d.stale[s]
+
+		res = append(res, snapshot)
+	}
+
+	for s := range d.consolidated {
+		if snapshot, ok := d.mergedSnapshot(s, levels); ok {
+			res = append(res, snapshot)
+		}
+	}
+
+	return res
+}
+
+// Symbols returns the configured symbols with their current status
+func (d *DepthGateService) Symbols() []SymbolStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	res := make([]SymbolStatus, 0, len(d.symbols))
+
+	for _, s := range d.symbols {
+		status := SymbolStatus{Symbol: s, Status: SymbolStatusPending}
+
+		if book, ok := d.books[s]; ok {
+			status.UpdatedAt = book.updatedAt
+		}
+
+		if updatedAt, ok := d.mergedUpdatedAt(s); ok {
+			status.UpdatedAt = updatedAt
+		}
+
+		switch {
+		case d.isStale(s):
+			status.Status = SymbolStatusStale
+		case d.isSynced(s):
+			status.Status = SymbolStatusLive
+		}
+
+		res = append(res, status)
+	}
+
+	return res
+}
+
+// isSynced reports whether the book, or any book of a consolidated symbol, got a snapshot, d.mu must be held
+func (d *DepthGateService) isSynced(s symbol) bool {
+	if book, ok := d.books[s]; ok && book.synced {
+		return true
+	}
+
+	for _, member := range d.consolidated[s] {
+		if book, ok := d.books[member]; ok && book.synced {
+			return true
+		}
+	}
+
+	return false
+}
+
+// LastUpdates returns when each symbol's book last changed
+func (d *DepthGateService) LastUpdates() map[string]time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	res := make(map[string]time.Time, len(d.books))
 
-		return err
+	for s, book := range d.books {
+		res[s] = book.updatedAt
 	}
 
-	return nil
+	return res
 }
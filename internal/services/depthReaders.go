@@ -0,0 +1,70 @@
+package services
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+type readResult struct {
+	response BookEvent
+	err      error
+}
+
+// DepthReaders merges several readers into one, each reader is read on its own goroutine
+// and io.EOF is returned once every reader has finished
+type DepthReaders struct {
+	results chan readResult
+	once    sync.Once
+	readers []DepthReader
+}
+
+func NewDepthReaders(readers ...DepthReader) *DepthReaders {
+	return &DepthReaders{results: make(chan readResult), readers: readers}
+}
+
+func (rs *DepthReaders) start() {
+	var wg sync.WaitGroup
+
+	for _, r := range rs.readers {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				var response BookEvent
+
+				err := r.ReadJSON(&response)
+
+				if errors.Is(err, io.EOF) {
+					return
+				}
+
+				rs.results <- readResult{response: response, err: err}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(rs.results)
+	}()
+}
+
+func (rs *DepthReaders) ReadJSON(target *BookEvent) error {
+	rs.once.Do(rs.start)
+
+	res, ok := <-rs.results
+	if !ok {
+		return io.EOF
+	}
+
+	if res.err != nil {
+		return res.err
+	}
+
+	*target = res.response
+
+	return nil
+}
@@ -0,0 +1,33 @@
+package services
+
+import (
+	"context"
+	"errors"
+)
+
+// DepthWriters fans every write out to all sinks, one failing sink does not stop the others
+type DepthWriters []DepthWriter
+
+func (ws DepthWriters) WriteJSON(ctx context.Context, target DepthWriterRequest) error {
+	errs := make([]error, 0)
+
+	for _, w := range ws {
+		if err := w.WriteJSON(ctx, target); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (ws DepthWriters) BulkWriteJSON(ctx context.Context, target []DepthWriterRequest) error {
+	errs := make([]error, 0)
+
+	for _, w := range ws {
+		if err := w.BulkWriteJSON(ctx, target); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,302 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxGroupingsPerSymbol bounds the cached groupings of a symbol, the least recently used goes first
+const maxGroupingsPerSymbol = 16
+
+// maxBucketTicks bounds the bucket a book can be grouped by, in multiples of its tick size
+const maxBucketTicks = 100000
+
+// inferredTickLevels are the best levels per side a tick size is inferred from when none is set
+const inferredTickLevels = 20
+
+type groupingKey struct {
+	symbol symbol
+	bucket price
+}
+
+// grouping is a whole book grouped by bucket, shared by every request of the same grouping
+// until the book changes
+type grouping struct {
+	version   uint64
+	bids      []PriceLevel
+	asks      []PriceLevel
+	updatedAt time.Time
+	usedAt    time.Time
+}
+
+// GroupedBook returns up to levels price levels per side of the symbol's book grouped into buckets
+// of bucket size, bids round down and asks round up to the bucket like exchange UIs do. Bucket is
+// a whole multiple of the symbol's tick size up to maxBucketTicks of them. Groupings are computed
+// from the whole book once per change and cached per symbol and bucket, the least recently used
+// beyond maxGroupingsPerSymbol are dropped.
+func (d *DepthGateService) GroupedBook(s string, bucket price, levels int) (BookSnapshot, bool, error) {
+	const op = "internal.services.depthGate.GroupedBook"
+
+	if bucket <= 0 || math.IsInf(bucket, 0) || math.IsNaN(bucket) {
+		return BookSnapshot{}, false, fmt.Errorf("%s: bucket must be positive", op)
+	}
+
+	s = NormalizeBookKey(s)
+
+	d.mu.Lock()
+
+	version, ok := d.bookVersion(s)
+	if !ok {
+		d.mu.Unlock()
+
+		return BookSnapshot{}, false, nil
+	}
+
+	tick := d.tickSize(s)
+
+	ticks := math.Round(bucket / tick)
+	if tick <= 0 || ticks < 1 || ticks > maxBucketTicks || !sameBucket(ticks*tick, bucket) {
+		d.mu.Unlock()
+
+		return BookSnapshot{}, false, fmt.Errorf("%s: bucket of %s must be a whole multiple of its tick size %v up to %d ticks", op, s, tick, maxBucketTicks)
+	}
+
+	// the multiple of the tick keys the cache, a float error in the requested size does not make a new grouping
+	bucket = roundPrice(ticks*tick, bucketDecimals(tick))
+	key := groupingKey{symbol: s, bucket: bucket}
+
+	stale := d.isStale(s)
+
+	g, ok := d.groupings[key]
+	if ok && g.version == version {
+		g.usedAt = time.Now()
+		d.mu.Unlock()
+
+		return g.book(s, bucket, levels, stale), true, nil
+	}
+
+	full, _ := d.snapshot(s, 0)
+
+	d.mu.Unlock()
+
+	// grouping the copy leaves the gate free to apply updates meanwhile
+	decimals := bucketDecimals(bucket)
+
+	g = &grouping{
+		version:   version,
+		bids:      groupLevels(full.Bids, bucket, decimals, true),
+		asks:      groupLevels(full.Asks, bucket, decimals, false),
+		updatedAt: full.UpdatedAt,
+		usedAt:    time.Now(),
+	}
+
+	d.mu.Lock()
+
+	// a request grouping a newer version meanwhile keeps its grouping
+	if cached, ok := d.groupings[key]; !ok || cached.version < version {
+		if !ok {
+			d.evictGrouping(s)
+		}
+
+		d.groupings[key] = g
+	}
+
+	d.mu.Unlock()
+
+	return g.book(s, bucket, levels, stale), true, nil
+}
+
+func (g *grouping) book(s symbol, bucket price, levels int, stale bool) BookSnapshot {
+	return BookSnapshot{
+		Symbol:    s,
+		Bids:      limitLevels(g.bids, levels),
+		Asks:      limitLevels(g.asks, levels),
+		UpdatedAt: g.updatedAt,
+		Bucket:    bucket,
+		Stale:     stale,
+	}
+}
+
+// SetTickSize sets the price increment of a book, the buckets it can be grouped by are multiples of it
+func (d *DepthGateService) SetTickSize(s string, tick price) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.tickSizes[NormalizeBookKey(s)] = tick
+}
+
+// TickSize is the price increment the symbol's book is grouped by multiples of, zero without a book
+func (d *DepthGateService) TickSize(s string) price {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.tickSize(NormalizeBookKey(s))
+}
+
+// tickSize is the set tick size of a book or else the finest increment of its best prices,
+// a consolidated symbol's is the coarsest of its books. d.mu must be held.
+func (d *DepthGateService) tickSize(s symbol) price {
+	if members, ok := d.consolidated[s]; ok {
+		var tick price
+
+		for _, member := range members {
+			tick = max(tick, d.tickSize(member))
+		}
+
+		return tick
+	}
+
+	if tick, ok := d.tickSizes[s]; ok {
+		return tick
+	}
+
+	book, ok := d.books[s]
+	if !ok {
+		return 0
+	}
+
+	decimals := -1
+
+	for _, side := range []*bookSide{book.bids, book.asks} {
+		n := 0
+
+		side.each(func(p price, _ quantity) bool {
+			decimals = max(decimals, bucketDecimals(p))
+			n++
+
+			return n < inferredTickLevels
+		})
+	}
+
+	if decimals < 0 {
+		return 0
+	}
+
+	return roundPrice(math.Pow10(-decimals), decimals)
+}
+
+// sameBucket compares bucket sizes up to the float error of multiplying a tick size
+func sameBucket(a, b price) bool {
+	return math.Abs(a-b) <= 1e-9*max(a, b)
+}
+
+// bookVersion changes whenever the symbol's book does, a consolidated symbol's whenever any of
+// its books does. False means there is no book yet, d.mu must be held.
+func (d *DepthGateService) bookVersion(s symbol) (uint64, bool) {
+	if members, ok := d.consolidated[s]; ok {
+		var version uint64
+
+		found := false
+
+		for _, member := range members {
+			if book, ok := d.books[member]; ok {
+				found = true
+				version += book.version
+			}
+		}
+
+		return version, found
+	}
+
+	book, ok := d.books[s]
+	if !ok {
+		return 0, false
+	}
+
+	return book.version, true
+}
+
+// evictGrouping makes room for a new grouping of s by dropping its least recently used one, d.mu must be held
+func (d *DepthGateService) evictGrouping(s symbol) {
+	var oldest *groupingKey
+
+	count := 0
+
+	for key, g := range d.groupings {
+		if key.symbol != s {
+			continue
+		}
+
+		count++
+
+		if oldest == nil || g.usedAt.Before(d.groupings[*oldest].usedAt) {
+			k := key
+			oldest = &k
+		}
+	}
+
+	if count >= maxGroupingsPerSymbol {
+		delete(d.groupings, *oldest)
+	}
+}
+
+// groupLevels sums sorted levels into buckets whose edge is below the price when down is set and
+// above it otherwise. Levels are sorted best first so each bucket is a run of consecutive levels.
+func groupLevels(levels []PriceLevel, bucket price, decimals int, down bool) []PriceLevel {
+	res := make([]PriceLevel, 0)
+
+	for _, level := range levels {
+		// the epsilon keeps a price on an edge in its own bucket despite the float division
+		edge := math.Ceil(level.Price/bucket - 1e-9)
+		if down {
+			edge = math.Floor(level.Price/bucket + 1e-9)
+		}
+
+		p := roundPrice(edge*bucket, decimals)
+
+		if n := len(res); n > 0 && res[n-1].Price == p {
+			res[n-1].Quantity += level.Quantity
+			res[n-1].Venues = addVenues(res[n-1].Venues, level.Venues)
+
+			continue
+		}
+
+		res = append(res, PriceLevel{Price: p, Quantity: level.Quantity, Venues: addVenues(nil, level.Venues)})
+	}
+
+	return res
+}
+
+func addVenues(to, from map[string]quantity) map[string]quantity {
+	if len(from) == 0 {
+		return to
+	}
+
+	if to == nil {
+		to = make(map[string]quantity, len(from))
+	}
+
+	for venue, q := range from {
+		to[venue] += q
+	}
+
+	return to
+}
+
+// bucketDecimals is the number of decimals of bucket, e.g. 2 for 0.05
+func bucketDecimals(bucket price) int {
+	_, frac, ok := strings.Cut(strconv.FormatFloat(bucket, 'f', -1, 64), ".")
+	if !ok {
+		return 0
+	}
+
+	return len(frac)
+}
+
+// roundPrice drops the float error of a bucket edge, e.g. 0.30000000000000004 is 0.3
+func roundPrice(p price, decimals int) price {
+	scale := math.Pow10(decimals)
+
+	return math.Round(p*scale) / scale
+}
+
+func limitLevels(levels []PriceLevel, n int) []PriceLevel {
+	if n > 0 && len(levels) > n {
+		return levels[:n]
+	}
+
+	return levels
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGroupedBookBucketsAreTickMultiples(t *testing.T) {
+	d := NewDepthGateService(nil, []string{"btcusdt"}, nil, DepthWriters{})
+
+	d.apply(BookEvent{
+		Kind:   BookEventSnapshot,
+		Symbol: "BTCUSDT",
+		Bids:   []PriceLevel{{Price: 100.05, Quantity: 1}, {Price: 99.97, Quantity: 2}, {Price: 99.2, Quantity: 3}},
+		Asks:   []PriceLevel{{Price: 100.11, Quantity: 1}, {Price: 100.93, Quantity: 2}},
+	})
+
+	// without a tick size it is the finest increment of the prices
+	if got := d.TickSize("btcusdt"); got != 0.01 {
+		t.Fatalf("inferred tick size = %v, want 0.01", got)
+	}
+
+	// any whole number of ticks, e.g. 25 dollars of 0.01
+	if book, ok, err := d.GroupedBook("btcusdt", 25, 0); err != nil || !ok || book.Bucket != 25 || len(book.Bids) != 2 || book.Bids[1].Price != 75 {
+		t.Fatalf("grouped by 25 = %+v, %v, %v, want bid buckets at 100 and 75", book, ok, err)
+	}
+
+	d.SetTickSize("btcusdt", 0.05)
+
+	book, ok, err := d.GroupedBook("btcusdt", 1, 0)
+	if err != nil || !ok {
+		t.Fatalf("grouped by 1: %v, %v", ok, err)
+	}
+
+	wantBids := []PriceLevel{{Price: 100, Quantity: 1}, {Price: 99, Quantity: 5}}
+	wantAsks := []PriceLevel{{Price: 101, Quantity: 3}}
+
+	if !reflect.DeepEqual(book.Bids, wantBids) || !reflect.DeepEqual(book.Asks, wantAsks) {
+		t.Errorf("grouped book = %v / %v, want %v / %v", book.Bids, book.Asks, wantBids, wantAsks)
+	}
+
+	if book, _, err := d.GroupedBook("btcusdt", 0.30000000000000004, 0); err != nil || book.Bucket != 0.3 {
+		t.Errorf("grouped by 6 ticks with a float error = %v, %v, want a bucket of 0.3", book.Bucket, err)
+	}
+
+	for _, bucket := range []price{0.12, 0.01, 0.05 * (maxBucketTicks + 1)} {
+		if _, _, err := d.GroupedBook("btcusdt", bucket, 0); err == nil {
+			t.Errorf("grouped by %v, want an error as it is no allowed multiple of 0.05", bucket)
+		}
+	}
+
+	// the cache keeps the most recently used groupings only
+	for ticks := 1; ticks <= 2*maxGroupingsPerSymbol; ticks++ {
+		if _, _, err := d.GroupedBook("btcusdt", 0.05*float64(ticks), 0); err != nil {
+			t.Fatalf("grouped by %d ticks: %v", ticks, err)
+		}
+	}
+
+	if n := len(d.groupings); n != maxGroupingsPerSymbol {
+		t.Errorf("cached groupings = %d, want %d", n, maxGroupingsPerSymbol)
+	}
+}
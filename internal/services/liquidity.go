@@ -0,0 +1,377 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"time"
+)
+
+// fillTolerance is the relative float error up to which a fill counts as complete
+const fillTolerance = 1e-9
+
+// Sides a fill walks, buys take the asks and sells hit the bids
+const (
+	SideBuy  = "buy"
+	SideSell = "sell"
+)
+
+// DepthPoint is a cumulative quantity with its notional, the sum of price times quantity
+type DepthPoint struct {
+	Quantity quantity `json:"quantity"`
+	Notional float64  `json:"notional"`
+}
+
+// CumulativeDepth is what rests between the top of book and Price, bids at or above it and asks at or below it
+type CumulativeDepth struct {
+	Symbol    symbol     `json:"symbol"`
+	Price     price      `json:"price"`
+	Bid       DepthPoint `json:"bid"`
+	Ask       DepthPoint `json:"ask"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+}
+
+// CumulativeLevel is a level of a depth curve with everything from the top of book to it
+type CumulativeLevel struct {
+	Price       price    `json:"price"`
+	Quantity    quantity `json:"quantity"`
+	CumQuantity quantity `json:"cumQuantity"`
+	CumNotional float64  `json:"cumNotional"`
+}
+
+// FillEstimate is the result of walking the book with a market order, Complete is false when
+// the book holds less than requested and the fill stops at its last level
+type FillEstimate struct {
+	Symbol     symbol   `json:"symbol"`
+	Side       string   `json:"side"`
+	Quantity   quantity `json:"quantity"`
+	Notional   float64  `json:"notional"`
+	VWAP       price    `json:"vwap"`
+	BestPrice  price    `json:"bestPrice"`
+	WorstPrice price    `json:"worstPrice"`
+	// SlippageBps is how much worse than BestPrice the VWAP is, in basis points
+	SlippageBps float64   `json:"slippageBps"`
+	Complete    bool      `json:"complete"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// Liquidity is what rests within Bps basis points of the mid price on each side
+type Liquidity struct {
+	Bps         float64  `json:"bps"`
+	Mid         price    `json:"mid"`
+	BidQuantity quantity `json:"bidQuantity"`
+	BidNotional float64  `json:"bidNotional"`
+	AskQuantity quantity `json:"askQuantity"`
+	AskNotional float64  `json:"askNotional"`
+}
+
+// Cumulative turns sorted levels into a depth curve
+func Cumulative(levels []PriceLevel) []CumulativeLevel {
+	res := make([]CumulativeLevel, 0, len(levels))
+
+	var point DepthPoint
+
+	for _, level := range levels {
+		point.Quantity += level.Quantity
+		point.Notional += level.Price * level.Quantity
+
+		res = append(res, CumulativeLevel{
+			Price:       level.Price,
+			Quantity:    level.Quantity,
+			CumQuantity: point.Quantity,
+			CumNotional: point.Notional,
+		})
+	}
+
+	return res
+}
+
+// CumulativeTo sums the symbol's book from the top down to p on both sides, each side is walked
+// in place and only down to p
+func (d *DepthGateService) CumulativeTo(s string, p price) (CumulativeDepth, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s = NormalizeBookKey(s)
+
+	books, updatedAt, ok := d.memberBooks(s)
+	if !ok {
+		return CumulativeDepth{}, false
+	}
+
+	res := CumulativeDepth{Symbol: s, Price: p, UpdatedAt: updatedAt}
+
+	for _, book := range books {
+		book.bids.each(func(level price, q quantity) bool {
+			if level < p {
+				return false
+			}
+
+			res.Bid.Quantity += q
+			res.Bid.Notional += level * q
+
+			return true
+		})
+
+		book.asks.each(func(level price, q quantity) bool {
+			if level > p {
+				return false
+			}
+
+			res.Ask.Quantity += q
+			res.Ask.Notional += level * q
+
+			return true
+		})
+	}
+
+	return res, true
+}
+
+// Fill estimates a market order of side for either a quantity or a notional, whichever is set
+func (d *DepthGateService) Fill(s string, side string, qty quantity, notional float64) (FillEstimate, bool, error) {
+	const op = "internal.services.depthGate.Fill"
+
+	if side != SideBuy && side != SideSell {
+		return FillEstimate{}, false, fmt.Errorf("%s: side must be %s or %s", op, SideBuy, SideSell)
+	}
+
+	if (qty > 0) == (notional > 0) {
+		return FillEstimate{}, false, fmt.Errorf("%s: exactly one of quantity and notional must be positive", op)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s = NormalizeBookKey(s)
+
+	books, updatedAt, ok := d.memberBooks(s)
+	if !ok {
+		return FillEstimate{}, false, nil
+	}
+
+	sides := make([]*bookSide, 0, len(books))
+
+	for _, book := range books {
+		if side == SideSell {
+			sides = append(sides, book.bids)
+		} else {
+			sides = append(sides, book.asks)
+		}
+	}
+
+	res := FillEstimate{Symbol: s, Side: side, UpdatedAt: updatedAt}
+
+	// remaining is what is left to fill, in quantity or notional as requested
+	remaining := func() float64 {
+		if qty > 0 {
+			return qty - res.Quantity
+		}
+
+		return notional - res.Notional
+	}
+
+	// the levels are walked in place best first across the books until the order is filled
+	eachMerged(sides, func(p price, q quantity) bool {
+		left := remaining()
+		if left <= fillTolerance*max(qty, notional) {
+			return false
+		}
+
+		take := q
+
+		if qty > 0 {
+			take = min(take, left)
+		} else {
+			take = min(take, left/p)
+		}
+
+		if res.BestPrice == 0 {
+			res.BestPrice = p
+		}
+
+		res.Quantity += take
+		res.Notional += take * p
+		res.WorstPrice = p
+
+		return true
+	})
+
+	res.Complete = remaining() <= fillTolerance*max(qty, notional)
+
+	if res.Quantity > 0 {
+		res.VWAP = res.Notional / res.Quantity
+		res.SlippageBps = math.Abs(res.VWAP-res.BestPrice) / res.BestPrice * 1e4
+	}
+
+	return res, true, nil
+}
+
+// Liquidity sums both sides of the symbol's book within every band of bps around the mid price,
+// false means the book has no mid because a side is empty
+func (d *DepthGateService) Liquidity(s string, bps []float64) ([]Liquidity, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s = NormalizeBookKey(s)
+
+	bid, ask, ok := d.bestPrices(s)
+	if !ok || bid == 0 || ask == 0 {
+		return nil, false
+	}
+
+	return d.liquidity(s, (bid+ask)/2, bps), true
+}
+
+// memberBooks are the book of s or a consolidated symbol's books with the latest change of any,
+// false means there is none yet. d.mu must be held.
+func (d *DepthGateService) memberBooks(s symbol) ([]*orderBook, time.Time, bool) {
+	members, ok := d.consolidated[s]
+	if !ok {
+		members = []symbol{s}
+	}
+
+	res := make([]*orderBook, 0, len(members))
+
+	var updatedAt time.Time
+
+	for _, member := range members {
+		book, ok := d.books[member]
+		if !ok {
+			continue
+		}
+
+		res = append(res, book)
+
+		if book.updatedAt.After(updatedAt) {
+			updatedAt = book.updatedAt
+		}
+	}
+
+	return res, updatedAt, len(res) > 0
+}
+
+// bestPrices are the best bid and ask of a book or across a consolidated symbol's books,
+// zero on an empty side. d.mu must be held.
+func (d *DepthGateService) bestPrices(s symbol) (price, price, bool) {
+	members, ok := d.consolidated[s]
+	if !ok {
+		members = []symbol{s}
+	}
+
+	var bid, ask price
+
+	found := false
+
+	for _, member := range members {
+		book, ok := d.books[member]
+		if !ok {
+			continue
+		}
+
+		found = true
+
+		if p, _ := book.bids.best(); p > bid {
+			bid = p
+		}
+
+		if p, _ := book.asks.best(); p > 0 && (ask == 0 || p < ask) {
+			ask = p
+		}
+	}
+
+	return bid, ask, found
+}
+
+// liquidity adds up the liquidity of a book or of a consolidated symbol's books around mid, d.mu must be held
+func (d *DepthGateService) liquidity(s symbol, mid price, bps []float64) []Liquidity {
+	members, ok := d.consolidated[s]
+	if !ok {
+		members = []symbol{s}
+	}
+
+	res := make([]Liquidity, len(bps))
+
+	for i, band := range bps {
+		res[i] = Liquidity{Bps: band, Mid: mid}
+	}
+
+	for _, member := range members {
+		if book, ok := d.books[member]; ok {
+			bookLiquidity(book, mid, bps, res)
+		}
+	}
+
+	return res
+}
+
+// SetLiquidityBands adds liquidity within every band of bps to each published update, none turns it off.
+// The bands are the same for every writer and are walked on every applied change. Call it before Serve.
+func (d *DepthGateService) SetLiquidityBands(bps []float64) error {
+	const op = "internal.services.SetLiquidityBands"
+
+	for _, band := range bps {
+		if !(band > 0) || math.IsInf(band, 0) {
+			return fmt.Errorf("%s: band %v bps is not a positive number", op, band)
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.liquidityBps = slices.Clone(bps)
+
+	return nil
+}
+
+// bookLiquidity adds the liquidity of a venue's book within each band of bps around mid to res,
+// mid is the published top of book which may come from a book ticker. Each side is walked
+// best first down to the edge of the widest band.
+func bookLiquidity(book *orderBook, mid price, bps []float64, res []Liquidity) {
+	if len(bps) == 0 {
+		return
+	}
+
+	lows := make([]price, len(bps))
+	highs := make([]price, len(bps))
+
+	for i, band := range bps {
+		lows[i], highs[i] = bandEdges(mid, band)
+	}
+
+	lowest, highest := slices.Min(lows), slices.Max(highs)
+
+	book.bids.each(func(p price, q quantity) bool {
+		if p < lowest {
+			return false
+		}
+
+		for i, low := range lows {
+			if p >= low {
+				res[i].BidQuantity += q
+				res[i].BidNotional += p * q
+			}
+		}
+
+		return true
+	})
+
+	book.asks.each(func(p price, q quantity) bool {
+		if p > highest {
+			return false
+		}
+
+		for i, high := range highs {
+			if p <= high {
+				res[i].AskQuantity += q
+				res[i].AskNotional += p * q
+			}
+		}
+
+		return true
+	})
+}
+
+func bandEdges(mid price, bps float64) (price, price) {
+	return mid * (1 - bps/1e4), mid * (1 + bps/1e4)
+}
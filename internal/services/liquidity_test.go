@@ -0,0 +1,87 @@
+package services
+
+import "testing"
+
+func TestLiquidityWithinBands(t *testing.T) {
+	d := NewDepthGateService(nil, []string{"btcusdt", "okx:btc-usdt"}, nil, DepthWriters{})
+
+	if _, err := d.Consolidate("BTC-USDT", []string{"btcusdt", "okx:btc-usdt"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the mid is 100, 10 bps reach 99.9 and 100.1 and 100 bps reach 99 and 101
+	d.apply(BookEvent{
+		Kind:   BookEventSnapshot,
+		Symbol: "BTCUSDT",
+		Bids:   []PriceLevel{{Price: 99.95, Quantity: 1}, {Price: 99.5, Quantity: 2}, {Price: 98, Quantity: 4}},
+		Asks:   []PriceLevel{{Price: 100.05, Quantity: 1}, {Price: 100.5, Quantity: 2}, {Price: 102, Quantity: 4}},
+	})
+	d.apply(BookEvent{
+		Kind:   BookEventSnapshot,
+		Symbol: "OKX:BTC-USDT",
+		Bids:   []PriceLevel{{Price: 99.9, Quantity: 3}},
+		Asks:   []PriceLevel{{Price: 100.9, Quantity: 5}},
+	})
+
+	tests := []struct {
+		symbol   string
+		bid, ask [2]quantity
+	}{
+		{symbol: "btcusdt", bid: [2]quantity{1, 3}, ask: [2]quantity{1, 3}},
+		{symbol: "NBBO:BTC-USDT", bid: [2]quantity{4, 6}, ask: [2]quantity{1, 8}},
+	}
+
+	for _, tt := range tests {
+		res, ok := d.Liquidity(tt.symbol, []float64{10, 100})
+		if !ok || len(res) != 2 {
+			t.Fatalf("%s: liquidity = %v, %v", tt.symbol, res, ok)
+		}
+
+		for i := range res {
+			if res[i].Mid != 100 || res[i].BidQuantity != tt.bid[i] || res[i].AskQuantity != tt.ask[i] {
+				t.Errorf("%s: %v bps = %+v, want bid %v and ask %v around 100", tt.symbol, res[i].Bps, res[i], tt.bid[i], tt.ask[i])
+			}
+		}
+	}
+}
+
+func TestCumulativeAndFillAcrossBooks(t *testing.T) {
+	d := NewDepthGateService(nil, []string{"btcusdt", "okx:btc-usdt"}, nil, DepthWriters{})
+
+	if _, err := d.Consolidate("BTC-USDT", []string{"btcusdt", "okx:btc-usdt"}); err != nil {
+		t.Fatal(err)
+	}
+
+	d.apply(BookEvent{
+		Kind:   BookEventSnapshot,
+		Symbol: "BTCUSDT",
+		Bids:   []PriceLevel{{Price: 99, Quantity: 1}, {Price: 97, Quantity: 4}},
+		Asks:   []PriceLevel{{Price: 101, Quantity: 1}, {Price: 103, Quantity: 4}},
+	})
+	d.apply(BookEvent{
+		Kind:   BookEventSnapshot,
+		Symbol: "OKX:BTC-USDT",
+		Bids:   []PriceLevel{{Price: 98, Quantity: 2}},
+		Asks:   []PriceLevel{{Price: 102, Quantity: 2}},
+	})
+
+	cum, ok := d.CumulativeTo("NBBO:BTC-USDT", 98)
+	if !ok || cum.Bid.Quantity != 3 || cum.Bid.Notional != 99+196 || cum.Ask.Quantity != 0 {
+		t.Errorf("cumulative to 98 = %+v, %v, want 3 bid across both books and no ask", cum, ok)
+	}
+
+	// a buy of 2 takes 101 from one book and 102 from the other
+	fill, ok, err := d.Fill("NBBO:BTC-USDT", SideBuy, 2, 0)
+	if err != nil || !ok {
+		t.Fatalf("fill: %v, %v", ok, err)
+	}
+
+	if !fill.Complete || fill.BestPrice != 101 || fill.WorstPrice != 102 || fill.Notional != 203 {
+		t.Errorf("fill = %+v, want 2 complete from 101 to 102 for 203", fill)
+	}
+
+	fill, _, _ = d.Fill("btcusdt", SideSell, 10, 0)
+	if fill.Complete || fill.Quantity != 5 || fill.WorstPrice != 97 {
+		t.Errorf("fill = %+v, want 5 of 10 down to 97", fill)
+	}
+}
@@ -0,0 +1,189 @@
+package services
+
+import (
+	"slices"
+	"sort"
+	"time"
+)
+
+type quantity = float64
+
+// PriceLevel is a single aggregated level of the order book.
+type PriceLevel struct {
+	Price    price    `json:"price"`
+	Quantity quantity `json:"quantity"`
+	// Venues splits Quantity by venue on consolidated books
+	Venues map[string]quantity `json:"venues,omitempty"`
+}
+
+// BookSnapshot is a point-in-time copy of the top levels of a symbol's book.
+type BookSnapshot struct {
+	Symbol    symbol       `json:"symbol"`
+	Bids      []PriceLevel `json:"bids"`
+	Asks      []PriceLevel `json:"asks"`
+	UpdatedAt time.Time    `json:"updatedAt"`
+	// Bucket is the price increment levels are grouped by, zero for the book as is
+	Bucket price `json:"bucket,omitempty"`
+	// Stale is a book restored at startup the upstream has not resynchronised yet
+	Stale bool `json:"stale,omitempty"`
+}
+
+type orderBook struct {
+	bids      *bookSide
+	asks      *bookSide
+	updatedAt time.Time
+	// version counts applied changes, groupings computed at an older version are stale
+	version uint64
+	// synced is set by the first snapshot, a book built from diffs alone is incomplete
+	synced bool
+}
+
+func newOrderBook() *orderBook {
+	return &orderBook{
+		bids: newBookSide(func(a, b price) bool { return a > b }),
+		asks: newBookSide(func(a, b price) bool { return a < b }),
+	}
+}
+
+// apply merges diff levels into the book, a zero quantity removes the level
+func (b *orderBook) apply(bids, asks []PriceLevel, at time.Time) {
+	b.bids.apply(bids)
+	b.asks.apply(asks)
+
+	b.updatedAt = at
+	b.version++
+}
+
+// bestBidLevel returns the best bid with its quantity, zeros for an empty side
+func (b *orderBook) bestBidLevel() (price, quantity) {
+	return b.bids.best()
+}
+
+func (b *orderBook) bestAskLevel() (price, quantity) {
+	return b.asks.best()
+}
+
+func (b *orderBook) snapshot(s symbol, levels int) BookSnapshot {
+	return BookSnapshot{
+		Symbol:    s,
+		Bids:      b.bids.top(levels),
+		Asks:      b.asks.top(levels),
+		UpdatedAt: b.updatedAt,
+	}
+}
+
+// bookSide is one side of a book, prices are kept sorted best first so the top of book
+// and the top levels never need a scan or a sort
+type bookSide struct {
+	levels map[price]quantity
+	prices []price
+	// better reports whether a is a better price than b on this side
+	better func(a, b price) bool
+}
+
+func newBookSide(better func(a, b price) bool) *bookSide {
+	return &bookSide{levels: make(map[price]quantity), better: better}
+}
+
+func (s *bookSide) apply(levels []PriceLevel) {
+	for _, level := range levels {
+		s.set(level.Price, level.Quantity)
+	}
+}
+
+// set changes the quantity at p, zero removes the level
+func (s *bookSide) set(p price, q quantity) {
+	_, exists := s.levels[p]
+
+	if q == 0 {
+		if exists {
+			delete(s.levels, p)
+
+			i := s.search(p)
+			s.prices = slices.Delete(s.prices, i, i+1)
+		}
+
+		return
+	}
+
+	if !exists {
+		s.prices = slices.Insert(s.prices, s.search(p), p)
+	}
+
+	s.levels[p] = q
+}
+
+// search is the index of p in prices or where it would be inserted
+func (s *bookSide) search(p price) int {
+	return sort.Search(len(s.prices), func(i int) bool { return !s.better(s.prices[i], p) })
+}
+
+func (s *bookSide) best() (price, quantity) {
+	if len(s.prices) == 0 {
+		return 0, 0
+	}
+
+	return s.prices[0], s.levels[s.prices[0]]
+}
+
+func (s *bookSide) get(p price) quantity {
+	return s.levels[p]
+}
+
+// top returns up to levels levels best first, levels <= 0 returns all of them
+func (s *bookSide) top(levels int) []PriceLevel {
+	n := len(s.prices)
+
+	if levels > 0 && levels < n {
+		n = levels
+	}
+
+	res := make([]PriceLevel, 0, n)
+
+	for _, p := range s.prices[:n] {
+		res = append(res, PriceLevel{Price: p, Quantity: s.levels[p]})
+	}
+
+	return res
+}
+
+// each walks the levels best first until fn returns false
+func (s *bookSide) each(fn func(p price, q quantity) bool) {
+	for _, p := range s.prices {
+		if !fn(p, s.levels[p]) {
+			return
+		}
+	}
+}
+
+// eachMerged walks the levels of sides of the same direction best first until fn returns false,
+// a price on several sides is walked once per side
+func eachMerged(sides []*bookSide, fn func(p price, q quantity) bool) {
+	next := make([]int, len(sides))
+
+	for {
+		best := -1
+
+		for i, s := range sides {
+			if next[i] == len(s.prices) {
+				continue
+			}
+
+			if best < 0 || s.better(s.prices[next[i]], sides[best].prices[next[best]]) {
+				best = i
+			}
+		}
+
+		if best < 0 {
+			return
+		}
+
+		s := sides[best]
+		p := s.prices[next[best]]
+		next[best]++
+
+		if !fn(p, s.levels[p]) {
+			return
+		}
+	}
+}
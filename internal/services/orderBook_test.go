@@ -0,0 +1,73 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOrderBookKeepsSidesSorted(t *testing.T) {
+	book := newOrderBook()
+
+	book.apply(
+		[]PriceLevel{{Price: 99, Quantity: 1}, {Price: 101, Quantity: 2}, {Price: 100, Quantity: 3}},
+		[]PriceLevel{{Price: 104, Quantity: 1}, {Price: 102, Quantity: 2}, {Price: 103, Quantity: 3}},
+		time.Now(),
+	)
+
+	if p, q := book.bestBidLevel(); p != 101 || q != 2 {
+		t.Fatalf("best bid = %v@%v, want 2@101", q, p)
+	}
+
+	if p, q := book.bestAskLevel(); p != 102 || q != 2 {
+		t.Fatalf("best ask = %v@%v, want 2@102", q, p)
+	}
+
+	// removing the best levels promotes the next ones, a zero quantity of a missing level is ignored
+	book.apply(
+		[]PriceLevel{{Price: 101, Quantity: 0}, {Price: 98, Quantity: 0}, {Price: 100, Quantity: 5}},
+		[]PriceLevel{{Price: 102, Quantity: 0}},
+		time.Now(),
+	)
+
+	snapshot := book.snapshot("BTCUSDT", 0)
+
+	wantBids := []PriceLevel{{Price: 100, Quantity: 5}, {Price: 99, Quantity: 1}}
+	wantAsks := []PriceLevel{{Price: 103, Quantity: 3}, {Price: 104, Quantity: 1}}
+
+	if !reflect.DeepEqual(snapshot.Bids, wantBids) {
+		t.Fatalf("bids = %v, want %v", snapshot.Bids, wantBids)
+	}
+
+	if !reflect.DeepEqual(snapshot.Asks, wantAsks) {
+		t.Fatalf("asks = %v, want %v", snapshot.Asks, wantAsks)
+	}
+
+	if top := book.snapshot("BTCUSDT", 1); len(top.Bids) != 1 || len(top.Asks) != 1 {
+		t.Fatalf("snapshot limited to 1 level = %v", top)
+	}
+}
+
+func TestOrderBookEmptySide(t *testing.T) {
+	book := newOrderBook()
+
+	if p, q := book.bestBidLevel(); p != 0 || q != 0 {
+		t.Fatalf("best bid of empty book = %v@%v", q, p)
+	}
+}
+
+func TestSymbolsLiveAfterSnapshot(t *testing.T) {
+	d := NewDepthGateService(nil, []string{"btcusdt"}, nil, DepthWriters{})
+
+	d.apply(BookEvent{Kind: BookEventDiff, Symbol: "BTCUSDT", Bids: []PriceLevel{{Price: 1, Quantity: 1}}})
+
+	if status := d.Symbols()[0].Status; status != SymbolStatusPending {
+		t.Fatalf("status after a diff = %s, want %s", status, SymbolStatusPending)
+	}
+
+	d.apply(BookEvent{Kind: BookEventSnapshot, Symbol: "BTCUSDT", Bids: []PriceLevel{{Price: 1, Quantity: 1}}})
+
+	if status := d.Symbols()[0].Status; status != SymbolStatusLive {
+		t.Fatalf("status after a snapshot = %s, want %s", status, SymbolStatusLive)
+	}
+}
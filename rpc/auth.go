@@ -0,0 +1,95 @@
+package rpc
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// authenticator checks the credential of a call, allows reports the symbols the caller may read
+type authenticator interface {
+	AuthenticateCredential(credential string) (allows func(symbol string) bool, err error)
+}
+
+type allowsKey struct{}
+
+// RegisterAuthenticator checks every call with a, without it every call may read every symbol
+func (s *GrpcServer) RegisterAuthenticator(a authenticator) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.auth = a
+}
+
+// authenticate adds what the caller may read to ctx, the credential is the authorization
+// metadata with a Bearer token or the x-api-key metadata
+func (s *GrpcServer) authenticate(ctx context.Context) (context.Context, error) {
+	s.mu.Lock()
+	auth := s.auth
+	s.mu.Unlock()
+
+	if auth == nil {
+		return ctx, nil
+	}
+
+	allows, err := auth.AuthenticateCredential(credentialOf(ctx))
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	return context.WithValue(ctx, allowsKey{}, allows), nil
+}
+
+func (s *GrpcServer) unaryAuth(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+func (s *GrpcServer) streamAuth(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.authenticate(ss.Context())
+	if err != nil {
+		return err
+	}
+
+	return handler(srv, authenticatedStream{ServerStream: ss, ctx: ctx})
+}
+
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+// allowsOf is what the caller of ctx may read, every symbol without an authenticator
+func allowsOf(ctx context.Context) func(symbol string) bool {
+	if allows, ok := ctx.Value(allowsKey{}).(func(symbol string) bool); ok {
+		return allows
+	}
+
+	return func(string) bool { return true }
+}
+
+func credentialOf(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	if values := md.Get("authorization"); len(values) > 0 && strings.HasPrefix(values[0], "Bearer ") {
+		return strings.TrimPrefix(values[0], "Bearer ")
+	}
+
+	if values := md.Get("x-api-key"); len(values) > 0 {
+		return values[0]
+	}
+
+	return ""
+}
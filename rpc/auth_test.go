@@ -0,0 +1,70 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+
+	internalServices "github.com/aggregate-binance-depth/internal/services"
+	"github.com/aggregate-binance-depth/rpc/depthpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// keyAuth accepts the key "secret" allowed to read BTCUSDT only
+type keyAuth struct{}
+
+func (keyAuth) AuthenticateCredential(credential string) (func(symbol string) bool, error) {
+	if credential != "secret" {
+		return nil, errors.New("invalid credentials")
+	}
+
+	return func(symbol string) bool { return internalServices.NormalizeBookKey(symbol) == "BTCUSDT" }, nil
+}
+
+type bookGate struct {
+	depthGateService
+}
+
+func (bookGate) Book(symbol string, levels int) (internalServices.BookSnapshot, bool) {
+	return internalServices.BookSnapshot{Symbol: internalServices.NormalizeBookKey(symbol)}, true
+}
+
+func TestGetBookIsAuthenticated(t *testing.T) {
+	s, err := NewGrpcServer(slog.New(slog.NewTextHandler(io.Discard, nil)), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.RegisterDepthGateService(bookGate{})
+	s.RegisterAuthenticator(keyAuth{})
+
+	getBook := func(ctx context.Context, req any) (any, error) {
+		return s.GetBook(ctx, req.(*depthpb.GetBookRequest))
+	}
+
+	tests := []struct {
+		symbol string
+		md     metadata.MD
+		want   codes.Code
+	}{
+		{symbol: "btcusdt", want: codes.Unauthenticated},
+		{symbol: "btcusdt", md: metadata.Pairs("authorization", "Bearer wrong"), want: codes.Unauthenticated},
+		{symbol: "btcusdt", md: metadata.Pairs("authorization", "Bearer secret"), want: codes.OK},
+		{symbol: "ethusdt", md: metadata.Pairs("x-api-key", "secret"), want: codes.PermissionDenied},
+	}
+
+	for _, tt := range tests {
+		ctx := metadata.NewIncomingContext(context.Background(), tt.md)
+
+		_, err := s.unaryAuth(ctx, &depthpb.GetBookRequest{Symbol: tt.symbol}, &grpc.UnaryServerInfo{}, getBook)
+
+		if got := status.Code(err); got != tt.want {
+			t.Errorf("GetBook(%s) with %v = %s, want %s", tt.symbol, tt.md, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,414 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/aggregate-binance-depth/infra"
+	internalServices "github.com/aggregate-binance-depth/internal/services"
+	"github.com/aggregate-binance-depth/rpc/depthpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	defaultBookLevels = 20
+	maxBookLevels     = 1000
+	certWatchInterval = 10 * time.Second
+)
+
+type id = int
+
+// GrpcServer is a DepthWriter sink serving books to gRPC clients
+type GrpcServer struct {
+	depthpb.UnimplementedDepthServiceServer
+
+	log              *slog.Logger
+	address          string
+	server           *grpc.Server
+	certReloader     *infra.CertReloader
+	certWatch        context.Context
+	stopCertWatch    context.CancelFunc
+	depthGateService depthGateService
+	auth             authenticator
+	subscribers      map[id]*subscriber
+	mu               sync.Mutex
+	closed           bool
+	maxId            int
+}
+
+type depthGateService interface {
+	Book(symbol string, levels int) (internalServices.BookSnapshot, bool)
+	GroupedBook(symbol string, bucket float64, levels int) (internalServices.BookSnapshot, bool, error)
+	Books(levels int) []internalServices.BookSnapshot
+	Symbols() []internalServices.SymbolStatus
+}
+
+type Options struct {
+	// Address is the listen address, e.g. "0.0.0.0:9090"
+	Address string
+	// TLS is optional, certificate files are watched and reloaded on change
+	TLS *infra.TLSFiles
+}
+
+func NewGrpcServer(l *slog.Logger, opts Options) (*GrpcServer, error) {
+	const op = "rpc.NewGrpcServer"
+
+	certWatch, stopCertWatch := context.WithCancel(context.Background())
+
+	s := &GrpcServer{
+		log:           l,
+		address:       opts.Address,
+		certWatch:     certWatch,
+		stopCertWatch: stopCertWatch,
+		subscribers:   make(map[id]*subscriber),
+	}
+
+	serverOpts := []grpc.ServerOption{grpc.UnaryInterceptor(s.unaryAuth), grpc.StreamInterceptor(s.streamAuth)}
+
+	if opts.TLS != nil {
+		certReloader, err := infra.NewCertReloader(l, *opts.TLS)
+		if err != nil {
+			stopCertWatch()
+
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		s.certReloader = certReloader
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(certReloader.TLSConfig())))
+	}
+
+	s.server = grpc.NewServer(serverOpts...)
+
+	depthpb.RegisterDepthServiceServer(s.server, s)
+
+	return s, nil
+}
+
+func (s *GrpcServer) RegisterDepthGateService(dgs depthGateService) error {
+	const op = "rpc.RegisterDepthGateService"
+
+	logger := s.log.With(slog.String("op", op))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.depthGateService != nil {
+		logger.Error("depth gate service already set")
+
+		return fmt.Errorf("%s: %s", op, "depth gate service already set")
+	}
+
+	s.depthGateService = dgs
+
+	return nil
+}
+
+// WriteJSON marks the symbol as updated for every subscribed stream
+func (s *GrpcServer) WriteJSON(ctx context.Context, target internalServices.DepthWriterRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sub := range s.subscribers {
+		sub.mark(target.Symbol)
+	}
+
+	return nil
+}
+
+func (s *GrpcServer) BulkWriteJSON(ctx context.Context, target []internalServices.DepthWriterRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sub := range s.subscribers {
+		for _, depth := range target {
+			sub.mark(depth.Symbol)
+		}
+	}
+
+	return nil
+}
+
+func (s *GrpcServer) GetBook(ctx context.Context, req *depthpb.GetBookRequest) (*depthpb.Book, error) {
+	levels, err := normalizeLevels(req.GetLevels())
+	if err != nil {
+		return nil, err
+	}
+
+	bucket, err := normalizeBucket(req.GetBucket())
+	if err != nil {
+		return nil, err
+	}
+
+	if !allowsOf(ctx)(req.GetSymbol()) {
+		return nil, status.Errorf(codes.PermissionDenied, "symbol %s is not allowed", req.GetSymbol())
+	}
+
+	book, ok, err := s.book(req.GetSymbol(), levels, bucket)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "symbol %q not found", req.GetSymbol())
+	}
+
+	return toBook(book), nil
+}
+
+func (s *GrpcServer) ListSymbols(ctx context.Context, req *depthpb.ListSymbolsRequest) (*depthpb.ListSymbolsResponse, error) {
+	symbols := s.depthGateService.Symbols()
+
+	res := &depthpb.ListSymbolsResponse{Symbols: make([]*depthpb.SymbolStatus, 0, len(symbols))}
+
+	allows := allowsOf(ctx)
+
+	for _, symbol := range symbols {
+		if !allows(symbol.Symbol) {
+			continue
+		}
+
+		st := &depthpb.SymbolStatus{Symbol: symbol.Symbol, Status: symbol.Status}
+
+		if !symbol.UpdatedAt.IsZero() {
+			st.UpdatedAt = timestamppb.New(symbol.UpdatedAt)
+		}
+
+		res.Symbols = append(res.Symbols, st)
+	}
+
+	return res, nil
+}
+
+// StreamBooks sends the current books then a fresh book of each updated symbol,
+// updates that arrive faster than the client reads are coalesced per symbol
+func (s *GrpcServer) StreamBooks(req *depthpb.StreamBooksRequest, stream grpc.ServerStreamingServer[depthpb.Book]) error {
+	const op = "rpc.StreamBooks"
+
+	levels, err := normalizeLevels(req.GetLevels())
+	if err != nil {
+		return err
+	}
+
+	bucket, err := normalizeBucket(req.GetBucket())
+	if err != nil {
+		return err
+	}
+
+	allows := allowsOf(stream.Context())
+
+	for _, symbol := range req.GetSymbols() {
+		if !allows(symbol) {
+			return status.Errorf(codes.PermissionDenied, "symbol %s is not allowed", symbol)
+		}
+	}
+
+	sub, err := s.subscribe(req.GetSymbols())
+	if err != nil {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+
+	logger := s.log.With(slog.String("op", op), slog.Int("subscriber", sub.id))
+	logger.Debug("stream opened")
+
+	defer func() {
+		s.unsubscribe(sub)
+		logger.Debug("stream closed")
+	}()
+
+	for _, book := range s.depthGateService.Books(levels) {
+		if !sub.wants(book.Symbol) || !allows(book.Symbol) {
+			continue
+		}
+
+		// a grouping of the same book, shared with every stream of the bucket. Books of other
+		// tick sizes are left out of a stream of every symbol, a requested one must fit the bucket.
+		if bucket > 0 {
+			if book, _, err = s.depthGateService.GroupedBook(book.Symbol, bucket, levels); err != nil {
+				if len(req.GetSymbols()) == 0 {
+					continue
+				}
+
+				return status.Error(codes.InvalidArgument, err.Error())
+			}
+		}
+
+		if err := stream.Send(toBook(book)); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-sub.done:
+			return status.Error(codes.Unavailable, "server is shutting down")
+		case <-sub.notify:
+			for _, symbol := range sub.take() {
+				if !allows(symbol) {
+					continue
+				}
+
+				book, ok, err := s.book(symbol, levels, bucket)
+				if err != nil || !ok {
+					continue
+				}
+
+				if err := stream.Send(toBook(book)); err != nil {
+					logger.Debug("error with Send", slog.String("error", err.Error()))
+
+					return err
+				}
+			}
+		}
+	}
+}
+
+func (s *GrpcServer) subscribe(symbols []string) (*subscriber, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, fmt.Errorf("server already closed")
+	}
+
+	sub := newSubscriber(s.maxId, symbols)
+	s.maxId++
+
+	s.subscribers[sub.id] = sub
+
+	return sub, nil
+}
+
+func (s *GrpcServer) unsubscribe(sub *subscriber) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.subscribers, sub.id)
+	sub.close()
+}
+
+func (s *GrpcServer) Serve() {
+	const op = "rpc.Serve"
+
+	logger := s.log.With(slog.String("op", op))
+
+	if s.closed {
+		logger.Error("server already closed")
+
+		return
+	}
+
+	lis, err := net.Listen("tcp", s.address)
+	if err != nil {
+		logger.Error("error with Listen", slog.String("error", err.Error()))
+
+		return
+	}
+
+	logger.Info("starting gRPC server on ", slog.String("address", s.address), slog.Bool("tls", s.certReloader != nil))
+
+	if s.certReloader != nil {
+		go s.certReloader.Watch(s.certWatch, certWatchInterval)
+	}
+
+	if err := s.server.Serve(lis); err != nil {
+		logger.Error("error with Serve", slog.String("error", err.Error()))
+	}
+}
+
+func (s *GrpcServer) Shutdown(ctx context.Context) {
+	const op = "rpc.Shutdown"
+
+	logger := s.log.With(slog.String("op", op))
+	logger.Debug("Shutting down server...")
+
+	// streams never finish on their own, release them before GracefulStop waits for them
+	func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.closed = true
+		for _, sub := range s.subscribers {
+			sub.close()
+		}
+	}()
+
+	stopped := make(chan struct{})
+
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		logger.Error("Error during server shutdown", slog.String("error", ctx.Err().Error()))
+		s.server.Stop()
+	}
+
+	s.stopCertWatch()
+
+	logger.Info("Server stopped")
+}
+
+func normalizeLevels(levels uint32) (int, error) {
+	if levels == 0 {
+		return defaultBookLevels, nil
+	}
+
+	if levels > maxBookLevels {
+		return 0, status.Errorf(codes.InvalidArgument, "levels must be between 1 and %d", maxBookLevels)
+	}
+
+	return int(levels), nil
+}
+
+// book is the symbol's book grouped by bucket unless bucket is zero
+func (s *GrpcServer) book(symbol string, levels int, bucket float64) (internalServices.BookSnapshot, bool, error) {
+	if bucket == 0 {
+		book, ok := s.depthGateService.Book(symbol, levels)
+
+		return book, ok, nil
+	}
+
+	return s.depthGateService.GroupedBook(symbol, bucket, levels)
+}
+
+func normalizeBucket(bucket float64) (float64, error) {
+	if bucket < 0 || math.IsNaN(bucket) || math.IsInf(bucket, 0) {
+		return 0, status.Error(codes.InvalidArgument, "bucket must be a positive price increment or 0")
+	}
+
+	return bucket, nil
+}
+
+func toBook(book internalServices.BookSnapshot) *depthpb.Book {
+	return &depthpb.Book{
+		Symbol:    book.Symbol,
+		Bids:      toPriceLevels(book.Bids),
+		Asks:      toPriceLevels(book.Asks),
+		UpdatedAt: timestamppb.New(book.UpdatedAt),
+		Bucket:    book.Bucket,
+	}
+}
+
+func toPriceLevels(levels []internalServices.PriceLevel) []*depthpb.PriceLevel {
+	res := make([]*depthpb.PriceLevel, 0, len(levels))
+
+	for _, level := range levels {
+		res = append(res, &depthpb.PriceLevel{Price: level.Price, Quantity: level.Quantity})
+	}
+
+	return res
+}
@@ -0,0 +1,22 @@
+package rpc
+
+import (
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+
+	"github.com/aggregate-binance-depth/infra"
+)
+
+func TestNewGrpcServerLoadsTLSFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := NewGrpcServer(slog.New(slog.NewTextHandler(io.Discard, nil)), Options{
+		Address: "localhost:0",
+		TLS:     &infra.TLSFiles{CertFile: filepath.Join(dir, "server.crt"), KeyFile: filepath.Join(dir, "server.key")},
+	})
+	if err == nil {
+		t.Fatal("NewGrpcServer with missing certificate files succeeded, want an error")
+	}
+}
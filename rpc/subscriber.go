@@ -0,0 +1,82 @@
+package rpc
+
+import (
+	"sync"
+
+	internalServices "github.com/aggregate-binance-depth/internal/services"
+)
+
+// subscriber collects updated symbols of one stream until the stream sends them
+type subscriber struct {
+	id        id
+	symbols   map[string]struct{}
+	pending   map[string]struct{}
+	mu        sync.Mutex
+	notify    chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newSubscriber(subscriberId id, symbols []string) *subscriber {
+	sub := &subscriber{
+		id:      subscriberId,
+		pending: make(map[string]struct{}),
+		notify:  make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+
+	if len(symbols) > 0 {
+		sub.symbols = make(map[string]struct{}, len(symbols))
+
+		for _, s := range symbols {
+			sub.symbols[internalServices.NormalizeBookKey(s)] = struct{}{}
+		}
+	}
+
+	return sub
+}
+
+func (sub *subscriber) wants(symbol string) bool {
+	if sub.symbols == nil {
+		return true
+	}
+
+	_, ok := sub.symbols[symbol]
+
+	return ok
+}
+
+func (sub *subscriber) mark(symbol string) {
+	if !sub.wants(symbol) {
+		return
+	}
+
+	sub.mu.Lock()
+	sub.pending[symbol] = struct{}{}
+	sub.mu.Unlock()
+
+	select {
+	case sub.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (sub *subscriber) take() []string {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	res := make([]string, 0, len(sub.pending))
+
+	for symbol := range sub.pending {
+		res = append(res, symbol)
+		delete(sub.pending, symbol)
+	}
+
+	return res
+}
+
+func (sub *subscriber) close() {
+	sub.closeOnce.Do(func() {
+		close(sub.done)
+	})
+}
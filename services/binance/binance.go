@@ -1,7 +1,9 @@
 package binance
 
 import (
+	"fmt"
 	"net/url"
+	"slices"
 	"strings"
 )
 
@@ -12,16 +14,177 @@ const (
 	queryWsStreamName    = "?streams="
 )
 
-type Binance struct{}
+// Environments of Binance market data, EnvCustom takes hosts from the config only
+const (
+	EnvSpot        = "spot"
+	EnvSpotTestnet = "spotTestnet"
+	EnvDataStream  = "dataStream"
+	EnvCustom      = "custom"
+)
+
+// Market is the product a symbol trades on, each market has its own hosts and books
+type Market string
+
+const (
+	MarketSpot Market = "spot"
+	// MarketUSDM is USDⓈ-M futures, e.g. BTCUSDT perpetual
+	MarketUSDM Market = "usdm"
+	// MarketCOINM is COIN-M futures, e.g. BTCUSD_PERP
+	MarketCOINM Market = "coinm"
+)
+
+// Markets lists every supported market
+var Markets = []Market{MarketSpot, MarketUSDM, MarketCOINM}
+
+// BookSymbol is the key of a symbol's book, spot symbols stay as they are so
+// futures books of the same pair are prefixed with their market
+func (m Market) BookSymbol(symbol string) string {
+	symbol = strings.ToUpper(symbol)
+
+	if m == MarketSpot || m == "" {
+		return symbol
+	}
+
+	return strings.ToUpper(string(m)) + ":" + symbol
+}
+
+func (m Market) valid() bool {
+	return slices.Contains(Markets, m)
+}
+
+type environment struct {
+	wsHosts     []string
+	restBaseUrl string
+}
+
+var futuresEnvironments = map[Market]environment{
+	MarketUSDM: {
+		wsHosts:     []string{"wss://fstream.binance.com"},
+		restBaseUrl: "https://fapi.binance.com",
+	},
+	MarketCOINM: {
+		wsHosts:     []string{"wss://dstream.binance.com"},
+		restBaseUrl: "https://dapi.binance.com",
+	},
+}
+
+// environments has no futures mirror for dataStream, its futures use the main futures hosts
+var environments = map[string]map[Market]environment{
+	EnvSpot: {
+		MarketSpot: {
+			wsHosts:     []string{"wss://stream.binance.com:9443", "wss://stream.binance.com:443"},
+			restBaseUrl: "https://api.binance.com",
+		},
+		MarketUSDM:  futuresEnvironments[MarketUSDM],
+		MarketCOINM: futuresEnvironments[MarketCOINM],
+	},
+	EnvSpotTestnet: {
+		MarketSpot: {
+			wsHosts:     []string{"wss://stream.testnet.binance.vision"},
+			restBaseUrl: "https://testnet.binance.vision",
+		},
+		MarketUSDM: {
+			wsHosts:     []string{"wss://stream.binancefuture.com"},
+			restBaseUrl: "https://testnet.binancefuture.com",
+		},
+		MarketCOINM: {
+			wsHosts:     []string{"wss://dstream.binancefuture.com"},
+			restBaseUrl: "https://testnet.binancefuture.com",
+		},
+	},
+	EnvDataStream: {
+		MarketSpot: {
+			wsHosts:     []string{marketWsHost},
+			restBaseUrl: "https://data-api.binance.vision",
+		},
+		MarketUSDM:  futuresEnvironments[MarketUSDM],
+		MarketCOINM: futuresEnvironments[MarketCOINM],
+	},
+}
+
+// Binance builds upstream urls of one market, a zero value points at the public spot market data host
+type Binance struct {
+	Market Market
+	// WsHosts are tried in order, the next one is used when a host is unreachable
+	WsHosts     []string
+	RestBaseUrl string
+}
+
+// NewBinance resolves env and market to their hosts, non empty wsHosts and restBaseUrl override the env's
+func NewBinance(env string, market Market, wsHosts []string, restBaseUrl string) (Binance, error) {
+	const op = "services.binance.NewBinance"
+
+	if env == "" {
+		env = EnvDataStream
+	}
+
+	if market == "" {
+		market = MarketSpot
+	}
 
+	if !market.valid() {
+		return Binance{}, fmt.Errorf("%s: unknown market %q", op, market)
+	}
+
+	b := Binance{Market: market, WsHosts: wsHosts, RestBaseUrl: restBaseUrl}
+
+	if env == EnvCustom {
+		if len(wsHosts) == 0 || restBaseUrl == "" {
+			return Binance{}, fmt.Errorf("%s: %s environment needs ws hosts and a rest base url", op, EnvCustom)
+		}
+
+		return b, nil
+	}
+
+	markets, ok := environments[env]
+	if !ok {
+		return Binance{}, fmt.Errorf("%s: unknown environment %q", op, env)
+	}
+
+	defaults := markets[market]
+
+	if len(b.WsHosts) == 0 {
+		b.WsHosts = defaults.wsHosts
+	}
+
+	if b.RestBaseUrl == "" {
+		b.RestBaseUrl = defaults.restBaseUrl
+	}
+
+	return b, nil
+}
+
+// CreateWsUrl builds the combined stream url on the first host
 func (b Binance) CreateWsUrl(streamName []string) (string, error) {
-	streams := strings.Join(streamName, "/")
-	endpoint, err := url.JoinPath(marketWsHost, wsPrefix)
-	resUrl := strings.Join([]string{endpoint, queryWsStreamName, streams}, "")
+	urls, err := b.CreateWsUrls(streamName)
 
 	if err != nil {
 		return "", err
 	}
 
-	return resUrl, nil
+	return urls[0], nil
+}
+
+// CreateWsUrls builds the combined stream url on every host in failover order
+func (b Binance) CreateWsUrls(streamName []string) ([]string, error) {
+	streams := strings.Join(streamName, "/")
+	hosts := b.WsHosts
+
+	if len(hosts) == 0 {
+		hosts = []string{marketWsHost}
+	}
+
+	res := make([]string, 0, len(hosts))
+
+	for _, host := range hosts {
+		endpoint, err := url.JoinPath(host, wsPrefix)
+
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, strings.Join([]string{endpoint, queryWsStreamName, streams}, ""))
+	}
+
+	return res, nil
 }
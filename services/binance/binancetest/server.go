@@ -0,0 +1,414 @@
+// Package binancetest runs an in-process fake of the Binance market data endpoints
+// for integration tests, in the spirit of net/http/httptest.
+package binancetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const writeTimeout = 5 * time.Second
+
+// DepthEvent is a diff depth update, zero update ids are assigned from the symbol's sequence
+type DepthEvent struct {
+	FirstUpdateId int64
+	FinalUpdateId int64
+	// PrevFinalUpdateId is the futures pu, by default the sequence before this event
+	PrevFinalUpdateId int64
+	Bids              [][]string
+	Asks              [][]string
+}
+
+// Snapshot is the REST depth snapshot of a symbol
+type Snapshot struct {
+	LastUpdateId int64      `json:"lastUpdateId"`
+	Bids         [][]string `json:"bids"`
+	Asks         [][]string `json:"asks"`
+}
+
+// SymbolInfo is the trading rules of a symbol served on the exchangeInfo paths
+type SymbolInfo struct {
+	Symbol     string
+	BaseAsset  string
+	QuoteAsset string
+	TickSize   string
+	StepSize   string
+}
+
+type symbolFilter struct {
+	FilterType string `json:"filterType"`
+	TickSize   string `json:"tickSize,omitempty"`
+	StepSize   string `json:"stepSize,omitempty"`
+}
+
+type exchangeSymbol struct {
+	Symbol     string         `json:"symbol"`
+	Status     string         `json:"status"`
+	BaseAsset  string         `json:"baseAsset"`
+	QuoteAsset string         `json:"quoteAsset"`
+	Filters    []symbolFilter `json:"filters"`
+}
+
+type depthUpdate struct {
+	Event             string     `json:"e"`
+	EventTime         int64      `json:"E"`
+	Symbol            string     `json:"s"`
+	FirstUpdateId     int64      `json:"U"`
+	FinalUpdateId     int64      `json:"u"`
+	PrevFinalUpdateId int64      `json:"pu"`
+	Bids              [][]string `json:"b"`
+	Asks              [][]string `json:"a"`
+}
+
+type combinedMessage struct {
+	Stream string `json:"stream"`
+	Data   any    `json:"data"`
+}
+
+type conn struct {
+	ws      *websocket.Conn
+	streams map[string]bool
+	mu      sync.Mutex
+}
+
+func (c *conn) write(data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ws.SetWriteDeadline(time.Now().Add(writeTimeout))
+
+	return c.ws.WriteMessage(websocket.TextMessage, data)
+}
+
+// Server serves the combined stream websocket on /stream and depth snapshots on the spot
+// /api/v3/depth and futures /fapi/v1/depth and /dapi/v1/depth paths, all markets share the books.
+// The exchangeInfo paths of every market serve the symbols set with SetSymbolInfo.
+// Every method is safe to call from the test goroutine while clients are connected.
+type Server struct {
+	// WsHost is a host for binance.Binance.WsHosts, e.g. "ws://127.0.0.1:41234"
+	WsHost string
+	// RestURL is the value for binance.Binance.RestBaseUrl, e.g. "http://127.0.0.1:41234"
+	RestURL string
+
+	http      *httptest.Server
+	upgrader  websocket.Upgrader
+	mu        sync.Mutex
+	conns     map[*conn]struct{}
+	connected chan struct{}
+	updateIds map[string]int64
+	snapshots map[string]Snapshot
+	infos     map[string]SymbolInfo
+	pongDelay time.Duration
+	refuse    bool
+}
+
+// NewServer starts a fake listening on a random local port, Close stops it
+func NewServer() *Server {
+	s := &Server{
+		conns:     make(map[*conn]struct{}),
+		connected: make(chan struct{}, 64),
+		updateIds: make(map[string]int64),
+		snapshots: make(map[string]Snapshot),
+		infos:     make(map[string]SymbolInfo),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stream", s.handleStream)
+	mux.HandleFunc("GET /api/v3/depth", s.handleDepth)
+	mux.HandleFunc("GET /fapi/v1/depth", s.handleDepth)
+	mux.HandleFunc("GET /dapi/v1/depth", s.handleDepth)
+	mux.HandleFunc("GET /api/v3/exchangeInfo", s.handleExchangeInfo)
+	mux.HandleFunc("GET /fapi/v1/exchangeInfo", s.handleExchangeInfo)
+	mux.HandleFunc("GET /dapi/v1/exchangeInfo", s.handleExchangeInfo)
+
+	s.http = httptest.NewServer(mux)
+	s.RestURL = s.http.URL
+	s.WsHost = "ws" + strings.TrimPrefix(s.http.URL, "http")
+
+	return s
+}
+
+// Close drops every connection and stops the server
+func (s *Server) Close() {
+	s.DropConnections()
+	s.http.Close()
+}
+
+func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	refuse := s.refuse
+	s.mu.Unlock()
+
+	if refuse {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+
+		return
+	}
+
+	ws, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	c := &conn{ws: ws, streams: make(map[string]bool)}
+
+	for _, stream := range strings.Split(r.URL.Query().Get("streams"), "/") {
+		if stream != "" {
+			c.streams[stream] = true
+		}
+	}
+
+	ws.SetPingHandler(func(data string) error {
+		s.mu.Lock()
+		delay := s.pongDelay
+		s.mu.Unlock()
+
+		// the pong is written off the read loop so a delay does not stall reading
+		go func() {
+			time.Sleep(delay)
+
+			c.mu.Lock()
+			defer c.mu.Unlock()
+
+			ws.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(writeTimeout))
+		}()
+
+		return nil
+	})
+
+	s.mu.Lock()
+	s.conns[c] = struct{}{}
+	s.mu.Unlock()
+
+	select {
+	case s.connected <- struct{}{}:
+	default:
+	}
+
+	// the read loop runs the ping handler and notices the client going away
+	for {
+		if _, _, err := ws.ReadMessage(); err != nil {
+			break
+		}
+	}
+
+	s.mu.Lock()
+	delete(s.conns, c)
+	s.mu.Unlock()
+
+	ws.Close()
+}
+
+func (s *Server) handleDepth(w http.ResponseWriter, r *http.Request) {
+	symbol := strings.ToUpper(r.URL.Query().Get("symbol"))
+
+	s.mu.Lock()
+	snapshot, ok := s.snapshots[symbol]
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]any{"code": -1121, "msg": "Invalid symbol."})
+
+		return
+	}
+
+	if limit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && limit > 0 {
+		snapshot.Bids = snapshot.Bids[:min(limit, len(snapshot.Bids))]
+		snapshot.Asks = snapshot.Asks[:min(limit, len(snapshot.Asks))]
+	}
+
+	json.NewEncoder(w).Encode(snapshot)
+}
+
+func (s *Server) handleExchangeInfo(w http.ResponseWriter, r *http.Request) {
+	var wanted []string
+
+	if raw := r.URL.Query().Get("symbols"); raw != "" {
+		json.Unmarshal([]byte(raw), &wanted)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+
+	res := make([]exchangeSymbol, 0, len(s.infos))
+
+	for symbol, info := range s.infos {
+		if len(wanted) == 0 || slices.Contains(wanted, symbol) {
+			res = append(res, exchangeSymbol{
+				Symbol:     symbol,
+				Status:     "TRADING",
+				BaseAsset:  info.BaseAsset,
+				QuoteAsset: info.QuoteAsset,
+				Filters: []symbolFilter{
+					{FilterType: "PRICE_FILTER", TickSize: info.TickSize},
+					{FilterType: "LOT_SIZE", StepSize: info.StepSize},
+				},
+			})
+		}
+	}
+
+	// like spot, a request naming an unknown symbol fails as a whole
+	for _, symbol := range wanted {
+		if _, ok := s.infos[symbol]; !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]any{"code": -1121, "msg": "Invalid symbol."})
+
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{"symbols": res})
+}
+
+// SetSymbolInfo sets the trading rules of a symbol, symbols without them are unknown to exchangeInfo
+func (s *Server) SetSymbolInfo(info SymbolInfo) {
+	info.Symbol = strings.ToUpper(info.Symbol)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.infos[info.Symbol] = info
+}
+
+// SetSnapshot sets the REST snapshot of symbol, later events continue from its LastUpdateId
+// so set it before emitting or the snapshot is older than the stream
+func (s *Server) SetSnapshot(symbol string, snapshot Snapshot) {
+	symbol = strings.ToUpper(symbol)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.snapshots[symbol] = snapshot
+	s.updateIds[symbol] = snapshot.LastUpdateId
+}
+
+// Emit sends data wrapped in a combined stream message to every connection subscribed to stream
+func (s *Server) Emit(stream string, data any) error {
+	payload, err := json.Marshal(combinedMessage{Stream: stream, Data: data})
+	if err != nil {
+		return err
+	}
+
+	return s.EmitRaw(stream, payload)
+}
+
+// EmitRaw sends payload as is to every connection subscribed to stream, e.g. a malformed frame
+func (s *Server) EmitRaw(stream string, payload []byte) error {
+	s.mu.Lock()
+	conns := make([]*conn, 0, len(s.conns))
+
+	for c := range s.conns {
+		if c.streams[stream] {
+			conns = append(conns, c)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, c := range conns {
+		if err := c.write(payload); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// EmitDepth sends a diff depth update on the symbol's <symbol>@depth stream
+func (s *Server) EmitDepth(symbol string, event DepthEvent) error {
+	symbol = strings.ToUpper(symbol)
+
+	s.mu.Lock()
+
+	// after InjectGap the sequence is ahead of the last event, so pu shows the gap as U does
+	if event.PrevFinalUpdateId == 0 {
+		event.PrevFinalUpdateId = s.updateIds[symbol]
+	}
+
+	if event.FirstUpdateId == 0 {
+		event.FirstUpdateId = s.updateIds[symbol] + 1
+	}
+
+	if event.FinalUpdateId == 0 {
+		event.FinalUpdateId = event.FirstUpdateId
+	}
+
+	s.updateIds[symbol] = event.FinalUpdateId
+	s.mu.Unlock()
+
+	return s.Emit(strings.ToLower(symbol)+"@depth", depthUpdate{
+		Event:             "depthUpdate",
+		EventTime:         time.Now().UnixMilli(),
+		Symbol:            symbol,
+		FirstUpdateId:     event.FirstUpdateId,
+		FinalUpdateId:     event.FinalUpdateId,
+		PrevFinalUpdateId: event.PrevFinalUpdateId,
+		Bids:              event.Bids,
+		Asks:              event.Asks,
+	})
+}
+
+// InjectGap skips n update ids of symbol so the next event does not follow the previous one
+func (s *Server) InjectGap(symbol string, n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.updateIds[strings.ToUpper(symbol)] += n
+}
+
+// DropConnections closes every connection without a close frame, as a network failure would
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for c := range s.conns {
+		c.ws.NetConn().Close()
+	}
+}
+
+// RefuseConnections makes new stream connections fail the handshake until called with false
+func (s *Server) RefuseConnections(refuse bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.refuse = refuse
+}
+
+// DelayPongs delays the answer to every client ping by d, zero answers at once
+func (s *Server) DelayPongs(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pongDelay = d
+}
+
+// Connections returns the number of open stream connections
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.conns)
+}
+
+// WaitForConnection blocks until a new stream connection is accepted or timeout passes
+func (s *Server) WaitForConnection(timeout time.Duration) error {
+	select {
+	case <-s.connected:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("no connection within %s", timeout)
+	}
+}
@@ -1,37 +1,89 @@
 package binance
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"log/slog"
 	"slices"
-	"strings"
+	"sync"
+	"time"
 
+	"github.com/aggregate-binance-depth/internal/metrics"
 	"github.com/aggregate-binance-depth/services"
 	"github.com/aggregate-binance-depth/services/binance/common"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
-	streamPostfix = "@depth"
+	// snapshotRetryInterval spaces snapshot requests of a symbol whose last request failed,
+	// snapshots are heavy on the REST rate limit
+	snapshotRetryInterval = 5 * time.Second
+	// snapshotMinInterval spaces snapshot requests of a symbol, a snapshot older than the
+	// buffered updates is requested again after it rather than in a loop
+	snapshotMinInterval = time.Second
+	// maxBufferedUpdates bounds the updates of a symbol kept while its snapshot is fetched,
+	// the oldest go first and the sync requests another snapshot if they were needed
+	maxBufferedUpdates = 10000
 )
 
 type DepthServiceWs struct {
 	log     *slog.Logger
 	wss     *services.WsService
 	binance Binance
+	syncs   map[string]*symbolSync
+	// pending holds responses ready to be returned, a snapshot goes before the updates following it
+	pending []DepthStreamResponse
+	// frames are read off the connection by readFrames, snapshots are fetched by fetchSnapshot
+	frames    chan frame
+	snapshots chan snapshotResult
+	readOnce  sync.Once
+	// recorder captures fetched snapshots, see SetRecorder
+	recorder services.FrameRecorder
+	// ctx ends with the connection, cancelling snapshot requests in flight
+	ctx context.Context
+}
+
+type symbolSync struct {
+	depthSync
+	snapshotted bool
+	// retryAt is the earliest next snapshot request of the symbol
+	retryAt time.Time
+	// fetching is set while a snapshot request is in flight, updates are buffered meanwhile
+	fetching bool
+	buffered []DepthStreamResponse
+}
+
+type frame struct {
+	resp DepthStreamResponse
+	err  error
+}
+
+type snapshotResult struct {
+	symbol   string
+	trigger  DepthStreamResponse
+	snapshot DepthSnapshot
+	err      error
 }
 
 type StreamData struct {
 }
 
-func NewDepthServiceWs(l *slog.Logger, symbols []string, wss *services.WsService) (*DepthServiceWs, error) {
+// NewDepthServiceWs subscribes the depth stream of every symbol and the extra streams, e.g. bookTicker
+func NewDepthServiceWs(l *slog.Logger, binance Binance, symbols []string, wss *services.WsService, extra ...StreamKind) (*DepthServiceWs, error) {
 	const op = "services.binance.depthServiceWs"
 
 	logger := l.With(slog.String("op", op))
 
-	var binance Binance
+	streamNames := make([]string, 0, len(symbols)*(len(extra)+1))
+	kinds := append([]StreamKind{StreamDepth}, extra...)
 
-	streamNames := make([]string, 0, len(symbols))
+	for _, kind := range kinds {
+		if !slices.Contains(StreamKinds, kind) {
+			logger.Error("stream is not supported", slog.String("stream", string(kind)))
+
+			return nil, fmt.Errorf("%s: unknown stream %q", op, kind)
+		}
+	}
 
 	for symbol := range slices.Values(symbols) {
 		if err := validateSymbol(symbol); err != nil {
@@ -40,10 +92,12 @@ func NewDepthServiceWs(l *slog.Logger, symbols []string, wss *services.WsService
 			return nil, fmt.Errorf("%s: %w", op, err)
 		}
 
-		streamNames = append(streamNames, strings.Join([]string{symbol, streamPostfix}, ""))
+		for _, kind := range kinds {
+			streamNames = append(streamNames, kind.streamName(symbol))
+		}
 	}
 
-	url, err := binance.CreateWsUrl(streamNames)
+	urls, err := binance.CreateWsUrls(streamNames)
 
 	if err != nil {
 		logger.Error("error with create wsl url", slog.String("error", err.Error()))
@@ -51,21 +105,100 @@ func NewDepthServiceWs(l *slog.Logger, symbols []string, wss *services.WsService
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	if err := wss.Connect(l, url); err != nil {
+	if err := wss.Connect(l, urls...); err != nil {
 		logger.Error("error with init connection", slog.String("error", err.Error()))
 
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	return &DepthServiceWs{wss: wss, log: l}, nil
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// in flight snapshot requests end with the connection
+	go func() {
+		<-wss.Done()
+		cancel()
+	}()
+
+	return &DepthServiceWs{
+		wss:       wss,
+		log:       l,
+		binance:   binance,
+		syncs:     make(map[string]*symbolSync),
+		frames:    make(chan frame),
+		snapshots: make(chan snapshotResult),
+		ctx:       ctx,
+	}, nil
+}
+
+// Market returns the market the service streams
+func (d *DepthServiceWs) Market() Market {
+	return d.binance.Market
 }
 
+// SetRecorder tees every snapshot fetched from now on to recorder under the market's SnapshotSource,
+// the stream frames are recorded by the connection. It must be set before the first ReadJSON.
+func (d *DepthServiceWs) SetRecorder(recorder services.FrameRecorder) {
+	d.recorder = recorder
+}
+
+// ReadJSON returns the next message, depth updates are kept in sync with the REST snapshot
+// and responses with Snapshot set replace the book instead of changing it. Snapshots are
+// fetched in the background so one symbol's request never holds the others' updates.
 func (d *DepthServiceWs) ReadJSON(target *DepthStreamResponse) error {
 	const op = "services.binance.ReadJSON"
 
+	d.readOnce.Do(func() {
+		go d.readFrames()
+	})
+
+	for len(d.pending) == 0 {
+		select {
+		case <-d.ctx.Done():
+			return fmt.Errorf("%s: %w", op, d.ctx.Err())
+		case res := <-d.snapshots:
+			d.applySnapshot(res)
+		case f := <-d.frames:
+			if f.err != nil {
+				return f.err
+			}
+
+			if f.resp.Kind != StreamDepth {
+				d.pending = append(d.pending, f.resp)
+
+				continue
+			}
+
+			d.sync(f.resp)
+		}
+	}
+
+	*target = d.pending[0]
+	d.pending = d.pending[1:]
+
+	return nil
+}
+
+// readFrames hands every frame of the connection to ReadJSON until it is disconnected for good
+func (d *DepthServiceWs) readFrames() {
+	for {
+		var f frame
+
+		f.err = d.read(&f.resp)
+
+		select {
+		case d.frames <- f:
+		case <-d.ctx.Done():
+			return
+		}
+	}
+}
+
+func (d *DepthServiceWs) read(target *DepthStreamResponse) error {
+	const op = "services.binance.ReadJSON"
+
 	logger := d.log.With(slog.String("op", op))
 
-	t, r, err := d.wss.ReadMessage()
+	sc, t, r, err := d.wss.ReadTracedMessage()
 
 	logger.Debug("Type", slog.Any("t", t))
 
@@ -75,27 +208,175 @@ func (d *DepthServiceWs) ReadJSON(target *DepthStreamResponse) error {
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
-	if err := json.Unmarshal(r, target); err != nil {
+	if err := DecodeStreamFrame(r, target); err != nil {
+		metrics.DecodeErrors.WithLabelValues("upstream").Inc()
 		logger.Error("error with Unmarshal", slog.String("error", err.Error()))
 
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
+	metrics.UpstreamMessages.WithLabelValues(target.Stream).Inc()
+
+	target.Trace = sc
+
 	return nil
 }
 
+// sync queues resp when it continues the symbol's book, requesting a snapshot first
+// when the book has none yet or the update leaves a gap
+func (d *DepthServiceWs) sync(resp DepthStreamResponse) {
+	const op = "services.binance.sync"
+
+	logger := d.log.With(slog.String("op", op), slog.String("symbol", resp.Data.Symbol))
+
+	s, ok := d.syncs[resp.Data.Symbol]
+	if !ok {
+		s = &symbolSync{depthSync: depthSync{market: d.binance.Market}}
+		d.syncs[resp.Data.Symbol] = s
+	}
+
+	if s.fetching {
+		s.buffer(resp)
+
+		return
+	}
+
+	if !s.snapshotted {
+		d.fetchSnapshot(s, resp)
+
+		return
+	}
+
+	switch s.next(resp.Data) {
+	case syncApply:
+		d.pending = append(d.pending, resp)
+	case syncResync:
+		metrics.UpstreamResyncs.WithLabelValues(string(d.binance.Market)).Inc()
+		logger.Warn("depth update gap, resyncing",
+			slog.Int64("lastUpdateId", s.lastUpdateId),
+			slog.Int64("U", resp.Data.FirstUpdateId),
+			slog.Int64("u", resp.Data.FinalUpdateId),
+			slog.Int64("pu", resp.Data.PrevFinalUpdateId),
+		)
+
+		s.snapshotted = false
+		d.fetchSnapshot(s, resp)
+	}
+}
+
+// fetchSnapshot requests the REST book of resp's symbol in the background and buffers resp
+// until it arrives, the request waits for the symbol's retryAt
+func (d *DepthServiceWs) fetchSnapshot(s *symbolSync, resp DepthStreamResponse) {
+	wait := time.Until(s.retryAt)
+
+	s.fetching = true
+	s.retryAt = time.Now().Add(max(wait, 0) + snapshotMinInterval)
+	s.buffer(resp)
+
+	go func() {
+		res := snapshotResult{symbol: resp.Data.Symbol, trigger: resp}
+
+		select {
+		case <-time.After(wait):
+		case <-d.ctx.Done():
+			return
+		}
+
+		res.snapshot, res.err = d.binance.DepthSnapshot(d.ctx, resp.Data.Symbol)
+
+		if res.err == nil && d.recorder != nil {
+			d.record(res)
+		}
+
+		select {
+		case d.snapshots <- res:
+		case <-d.ctx.Done():
+		}
+	}()
+}
+
+// applySnapshot queues a fetched snapshot then the updates buffered while it was fetched
+func (d *DepthServiceWs) applySnapshot(res snapshotResult) {
+	const op = "services.binance.applySnapshot"
+
+	logger := d.log.With(slog.String("op", op), slog.String("symbol", res.symbol))
+
+	s := d.syncs[res.symbol]
+	buffered := s.buffered
+
+	s.fetching = false
+	s.buffered = nil
+
+	if res.err != nil {
+		logger.Error("error with DepthSnapshot", slog.String("error", res.err.Error()))
+		s.retryAt = time.Now().Add(snapshotRetryInterval)
+
+		return
+	}
+
+	s.reset(res.snapshot.LastUpdateId)
+	s.snapshotted = true
+
+	snapshot := newSnapshotResponse(res.symbol, res.snapshot)
+	snapshot.Trace = res.trigger.Trace
+	snapshot.Stream = res.trigger.Stream
+
+	d.pending = append(d.pending, snapshot)
+
+	for _, resp := range buffered {
+		d.sync(resp)
+	}
+}
+
+// record captures a fetched snapshot next to the stream frames so a replay can sync from it
+func (d *DepthServiceWs) record(res snapshotResult) {
+	const op = "services.binance.record"
+
+	frame, err := EncodeSnapshotFrame(res.symbol, res.snapshot)
+	if err != nil {
+		d.log.Error("error with EncodeSnapshotFrame", slog.String("op", op), slog.String("error", err.Error()))
+
+		return
+	}
+
+	d.recorder.Record(d.binance.Market.SnapshotSource(), time.Now(), frame)
+}
+
+func (s *symbolSync) buffer(resp DepthStreamResponse) {
+	if len(s.buffered) >= maxBufferedUpdates {
+		s.buffered = s.buffered[1:]
+	}
+
+	s.buffered = append(s.buffered, resp)
+}
+
 func validateSymbol(string) error {
 	return nil
 }
 
-// DepthStreamResponse represents the entire WebSocket message
+// DepthStreamResponse represents the entire WebSocket message, the payload is decoded
+// into Data, BookTicker or AggTrade by Kind
 type DepthStreamResponse struct {
-	Stream string `json:"stream"`
-	Data   struct {
-		Symbol string     `json:"s"` // Symbol (e.g., "BTCUSDT")
-		Bids   [][]string `json:"b"` // Bids (array of [price, quantity])
-		Asks   [][]string `json:"a"` // Asks (array of [price, quantity])
-	} `json:"data"`
+	// Trace is the span context of the upstream read, not part of the payload
+	Trace      trace.SpanContext `json:"-"`
+	Stream     string            `json:"stream"`
+	Kind       StreamKind        `json:"-"`
+	Data       DepthEvent        `json:"data"`
+	BookTicker BookTickerEvent   `json:"-"`
+	AggTrade   AggTradeEvent     `json:"-"`
+	// Snapshot marks a REST book replacing the local one, not part of the payload
+	Snapshot bool `json:"-"`
+}
+
+// DepthEvent is a diff depth update of spot or futures
+type DepthEvent struct {
+	Symbol        string `json:"s"` // Symbol (e.g., "BTCUSDT")
+	FirstUpdateId int64  `json:"U"` // First update ID in event
+	FinalUpdateId int64  `json:"u"` // Final update ID in event
+	// PrevFinalUpdateId is the final update ID of the previous event, futures only
+	PrevFinalUpdateId int64      `json:"pu"`
+	Bids              [][]string `json:"b"` // Bids (array of [price, quantity])
+	Asks              [][]string `json:"a"` // Asks (array of [price, quantity])
 }
 
 // Ask is a type alias for PriceLevel.
@@ -0,0 +1,152 @@
+package binance
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// snapshotLimit is the deepest snapshot every market serves
+	snapshotLimit = 1000
+	restTimeout   = 10 * time.Second
+)
+
+var depthPaths = map[Market]string{
+	MarketSpot:  "/api/v3/depth",
+	MarketUSDM:  "/fapi/v1/depth",
+	MarketCOINM: "/dapi/v1/depth",
+}
+
+// DepthSnapshot is the REST order book a diff stream is synced from
+type DepthSnapshot struct {
+	LastUpdateId int64      `json:"lastUpdateId"`
+	Bids         [][]string `json:"bids"`
+	Asks         [][]string `json:"asks"`
+}
+
+// codeInvalidSymbol is the API error code of a symbol the market does not list
+const codeInvalidSymbol = -1121
+
+// apiError is the error body of a failed REST request
+type apiError struct {
+	Status int    `json:"-"`
+	Code   int    `json:"code"`
+	Msg    string `json:"msg"`
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("status %d: %s", e.Status, e.Msg)
+}
+
+var restClient = &http.Client{Timeout: restTimeout}
+
+// DepthSnapshot fetches the REST order book of symbol from the market's depth endpoint
+func (b Binance) DepthSnapshot(ctx context.Context, symbol string) (DepthSnapshot, error) {
+	const op = "services.binance.DepthSnapshot"
+
+	query := url.Values{}
+	query.Set("symbol", strings.ToUpper(symbol))
+	query.Set("limit", strconv.Itoa(snapshotLimit))
+
+	var snapshot DepthSnapshot
+
+	if err := b.get(ctx, depthPaths, query, &snapshot); err != nil {
+		return DepthSnapshot{}, fmt.Errorf("%s: %s: %w", op, symbol, err)
+	}
+
+	return snapshot, nil
+}
+
+// get decodes the JSON response of the market's path in paths into target
+func (b Binance) get(ctx context.Context, paths map[Market]string, query url.Values, target any) error {
+	market := b.Market
+	if market == "" {
+		market = MarketSpot
+	}
+
+	endpoint, err := url.JoinPath(b.RestBaseUrl, paths[market])
+	if err != nil {
+		return err
+	}
+
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := restClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		apiErr := &apiError{Status: res.StatusCode}
+
+		json.NewDecoder(res.Body).Decode(apiErr)
+
+		return apiErr
+	}
+
+	return json.NewDecoder(res.Body).Decode(target)
+}
+
+// snapshotSourceSuffix tells a market's captured snapshots apart from its stream frames
+const snapshotSourceSuffix = ":snapshot"
+
+// snapshotFrame is a fetched snapshot as it is captured, Symbol is the venue symbol
+type snapshotFrame struct {
+	Symbol string `json:"symbol"`
+	DepthSnapshot
+}
+
+// SnapshotSource is the capture source of the market's REST snapshots
+func (m Market) SnapshotSource() string {
+	return string(m) + snapshotSourceSuffix
+}
+
+// SnapshotMarket returns the market of a capture source written by SnapshotSource
+func SnapshotMarket(source string) (Market, bool) {
+	market, ok := strings.CutSuffix(source, snapshotSourceSuffix)
+
+	return Market(market), ok
+}
+
+// EncodeSnapshotFrame is the capture frame of symbol's snapshot, DecodeSnapshotFrame reads it back
+func EncodeSnapshotFrame(symbol string, snapshot DepthSnapshot) ([]byte, error) {
+	return json.Marshal(snapshotFrame{Symbol: symbol, DepthSnapshot: snapshot})
+}
+
+// DecodeSnapshotFrame decodes a captured snapshot into target as DepthServiceWs returns it
+func DecodeSnapshotFrame(frame []byte, target *DepthStreamResponse) error {
+	var f snapshotFrame
+
+	if err := json.Unmarshal(frame, &f); err != nil {
+		return err
+	}
+
+	*target = newSnapshotResponse(f.Symbol, f.DepthSnapshot)
+
+	return nil
+}
+
+// newSnapshotResponse replaces the book of symbol with snapshot
+func newSnapshotResponse(symbol string, snapshot DepthSnapshot) DepthStreamResponse {
+	res := DepthStreamResponse{Stream: StreamDepth.streamName(symbol), Kind: StreamDepth, Snapshot: true}
+	res.Data.Symbol = symbol
+	res.Data.FinalUpdateId = snapshot.LastUpdateId
+	res.Data.Bids = snapshot.Bids
+	res.Data.Asks = snapshot.Asks
+
+	return res
+}
@@ -0,0 +1,78 @@
+package binance
+
+// depthSync keeps a symbol's diff stream continuous with its REST snapshot.
+// Spot events chain by U == previous u + 1, futures events carry the previous
+// u in pu. A gap means the local book misses updates and needs a new snapshot.
+type depthSync struct {
+	market       Market
+	synced       bool
+	lastUpdateId int64
+}
+
+type syncAction int
+
+const (
+	// syncApply merges the event into the book
+	syncApply syncAction = iota
+	// syncDrop skips an event the snapshot already contains
+	syncDrop
+	// syncResync fetches a new snapshot, the event does not follow the book
+	syncResync
+)
+
+func (s *depthSync) futures() bool {
+	return s.market == MarketUSDM || s.market == MarketCOINM
+}
+
+// reset starts the book over from a snapshot
+func (s *depthSync) reset(lastUpdateId int64) {
+	s.synced = false
+	s.lastUpdateId = lastUpdateId
+}
+
+// next decides what to do with an event, the first event applied after reset
+// must straddle the snapshot's lastUpdateId
+func (s *depthSync) next(e DepthEvent) syncAction {
+	if !s.synced {
+		return s.first(e)
+	}
+
+	continuous := e.FirstUpdateId == s.lastUpdateId+1
+
+	if s.futures() {
+		continuous = e.PrevFinalUpdateId == s.lastUpdateId
+	}
+
+	if !continuous {
+		return syncResync
+	}
+
+	s.lastUpdateId = e.FinalUpdateId
+
+	return syncApply
+}
+
+func (s *depthSync) first(e DepthEvent) syncAction {
+	// spot snapshots include every update up to lastUpdateId, futures ones up to the event ending on it
+	from := s.lastUpdateId + 1
+
+	if s.futures() {
+		from = s.lastUpdateId
+	}
+
+	if e.FinalUpdateId < from {
+		return syncDrop
+	}
+
+	// a futures event right after the snapshot's one continues it by pu
+	follows := s.futures() && e.PrevFinalUpdateId == s.lastUpdateId
+
+	if e.FirstUpdateId > from && !follows {
+		return syncResync
+	}
+
+	s.synced = true
+	s.lastUpdateId = e.FinalUpdateId
+
+	return syncApply
+}
@@ -0,0 +1,146 @@
+package binance
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+var exchangeInfoPaths = map[Market]string{
+	MarketSpot:  "/api/v3/exchangeInfo",
+	MarketUSDM:  "/fapi/v1/exchangeInfo",
+	MarketCOINM: "/dapi/v1/exchangeInfo",
+}
+
+// SymbolInfo is the trading rules of a symbol
+type SymbolInfo struct {
+	Symbol     string         `json:"symbol"`
+	BaseAsset  string         `json:"baseAsset"`
+	QuoteAsset string         `json:"quoteAsset"`
+	Filters    []SymbolFilter `json:"filters"`
+}
+
+// SymbolFilter is one trading rule, only the fields of the price and lot size filters are kept
+type SymbolFilter struct {
+	FilterType string `json:"filterType"`
+	TickSize   string `json:"tickSize"`
+	StepSize   string `json:"stepSize"`
+}
+
+// TickSize is the price increment of the symbol, empty when the price filter is missing
+func (s SymbolInfo) TickSize() string {
+	return s.filter("PRICE_FILTER").TickSize
+}
+
+// StepSize is the quantity increment of the symbol, empty when the lot size filter is missing
+func (s SymbolInfo) StepSize() string {
+	return s.filter("LOT_SIZE").StepSize
+}
+
+func (s SymbolInfo) filter(filterType string) SymbolFilter {
+	for _, f := range s.Filters {
+		if f.FilterType == filterType {
+			return f
+		}
+	}
+
+	return SymbolFilter{}
+}
+
+type exchangeInfo struct {
+	Symbols []SymbolInfo `json:"symbols"`
+}
+
+// ExchangeInfo fetches the trading rules of symbols from the market's exchangeInfo endpoint,
+// symbols the market does not list are missing from the result
+func (b Binance) ExchangeInfo(ctx context.Context, symbols []string) ([]SymbolInfo, error) {
+	const op = "services.binance.ExchangeInfo"
+
+	wanted := make([]string, 0, len(symbols))
+
+	for _, symbol := range symbols {
+		wanted = append(wanted, strings.ToUpper(symbol))
+	}
+
+	// only spot filters by symbol, futures always return every symbol
+	if b.Market != MarketSpot && b.Market != "" {
+		info, err := b.exchangeInfo(ctx, nil)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		return info.listed(wanted), nil
+	}
+
+	info, err := b.exchangeInfo(ctx, wanted)
+
+	var apiErr *apiError
+
+	// spot rejects the whole request for one symbol it does not list, each is asked alone then
+	if errors.As(err, &apiErr) && apiErr.Code == codeInvalidSymbol && len(wanted) > 1 {
+		info.Symbols = make([]SymbolInfo, 0, len(wanted))
+
+		for _, symbol := range wanted {
+			one, err := b.exchangeInfo(ctx, []string{symbol})
+
+			if errors.As(err, &apiErr) && apiErr.Code == codeInvalidSymbol {
+				continue
+			}
+
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", op, symbol, err)
+			}
+
+			info.Symbols = append(info.Symbols, one.Symbols...)
+		}
+
+		return info.listed(wanted), nil
+	}
+
+	if errors.As(err, &apiErr) && apiErr.Code == codeInvalidSymbol {
+		return []SymbolInfo{}, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return info.listed(wanted), nil
+}
+
+// exchangeInfo requests the rules of symbols, none requests every symbol
+func (b Binance) exchangeInfo(ctx context.Context, symbols []string) (exchangeInfo, error) {
+	query := url.Values{}
+
+	if len(symbols) > 0 {
+		list, err := json.Marshal(symbols)
+		if err != nil {
+			return exchangeInfo{}, err
+		}
+
+		query.Set("symbols", string(list))
+	}
+
+	var info exchangeInfo
+
+	err := b.get(ctx, exchangeInfoPaths, query, &info)
+
+	return info, err
+}
+
+// listed keeps the symbols in wanted
+func (info exchangeInfo) listed(wanted []string) []SymbolInfo {
+	res := make([]SymbolInfo, 0, len(wanted))
+
+	for _, s := range info.Symbols {
+		if slices.Contains(wanted, s.Symbol) {
+			res = append(res, s)
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,36 @@
+package binance
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aggregate-binance-depth/services/binance/binancetest"
+)
+
+func TestExchangeInfoSkipsUnknownSpotSymbols(t *testing.T) {
+	fake := binancetest.NewServer()
+	t.Cleanup(fake.Close)
+
+	fake.SetSymbolInfo(binancetest.SymbolInfo{Symbol: "BTCUSDT", BaseAsset: "BTC", QuoteAsset: "USDT", TickSize: "0.01", StepSize: "0.00001"})
+	fake.SetSymbolInfo(binancetest.SymbolInfo{Symbol: "ETHUSDT", BaseAsset: "ETH", QuoteAsset: "USDT", TickSize: "0.01", StepSize: "0.0001"})
+
+	b, err := NewBinance(EnvCustom, MarketSpot, []string{fake.WsHost}, fake.RestURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	infos, err := b.ExchangeInfo(context.Background(), []string{"btcusdt", "delisted", "ethusdt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]string)
+
+	for _, info := range infos {
+		got[info.Symbol] = info.TickSize()
+	}
+
+	if len(got) != 2 || got["BTCUSDT"] != "0.01" || got["ETHUSDT"] != "0.01" {
+		t.Errorf("infos = %+v, want BTCUSDT and ETHUSDT", infos)
+	}
+}
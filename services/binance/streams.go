@@ -0,0 +1,96 @@
+package binance
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// StreamKind is the type of a market stream, the part of the stream name after the symbol
+type StreamKind string
+
+const (
+	StreamDepth StreamKind = "depth"
+	// StreamBookTicker pushes every change of the best bid and ask with their sizes
+	StreamBookTicker StreamKind = "bookTicker"
+	StreamAggTrade   StreamKind = "aggTrade"
+)
+
+// StreamKinds lists every supported stream
+var StreamKinds = []StreamKind{StreamDepth, StreamBookTicker, StreamAggTrade}
+
+// streamName is the combined stream name of symbol, e.g. btcusdt@bookTicker
+func (k StreamKind) streamName(symbol string) string {
+	return strings.ToLower(symbol) + "@" + string(k)
+}
+
+// streamKind reads the kind of a stream name, depth streams may carry a speed such as @depth@100ms
+func streamKind(stream string) (StreamKind, error) {
+	_, name, _ := strings.Cut(stream, "@")
+	name, _, _ = strings.Cut(name, "@")
+
+	for _, kind := range StreamKinds {
+		if name == string(kind) {
+			return kind, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown stream %q", stream)
+}
+
+// BookTickerEvent is the best bid and ask of a symbol
+type BookTickerEvent struct {
+	UpdateId int64  `json:"u"`
+	Symbol   string `json:"s"`
+	BidPrice string `json:"b"`
+	BidQty   string `json:"B"`
+	AskPrice string `json:"a"`
+	AskQty   string `json:"A"`
+}
+
+// AggTradeEvent is a trade aggregated over the fills of one taker order at one price.
+// Keys differing only by case are all declared, encoding/json would match "e" to "E" otherwise.
+type AggTradeEvent struct {
+	Event     string `json:"e"`
+	EventTime int64  `json:"E"`
+	Symbol    string `json:"s"`
+	AggId     int64  `json:"a"`
+	Price     string `json:"p"`
+	Quantity  string `json:"q"`
+	// TradeTime is milliseconds since epoch
+	TradeTime    int64 `json:"T"`
+	BuyerIsMaker bool  `json:"m"`
+	// Ignore is an unused spot field, declared so it does not overwrite BuyerIsMaker
+	Ignore bool `json:"M"`
+}
+
+type combinedFrame struct {
+	Stream string          `json:"stream"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// DecodeStreamFrame decodes a combined stream frame into the field of target matching its stream
+func DecodeStreamFrame(frame []byte, target *DepthStreamResponse) error {
+	var combined combinedFrame
+
+	if err := json.Unmarshal(frame, &combined); err != nil {
+		return err
+	}
+
+	kind, err := streamKind(combined.Stream)
+	if err != nil {
+		return err
+	}
+
+	target.Stream = combined.Stream
+	target.Kind = kind
+
+	switch kind {
+	case StreamBookTicker:
+		return json.Unmarshal(combined.Data, &target.BookTicker)
+	case StreamAggTrade:
+		return json.Unmarshal(combined.Data, &target.AggTrade)
+	default:
+		return json.Unmarshal(combined.Data, &target.Data)
+	}
+}
@@ -0,0 +1,92 @@
+package okx
+
+import (
+	"cmp"
+	"fmt"
+	"hash/crc32"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// checksumLevels is how many levels of each side the checksum of a push covers
+const checksumLevels = 25
+
+// pushedLevel is a level as OKX pushed it, the checksum is over the strings as sent
+type pushedLevel struct {
+	price float64
+	raw   [2]string
+}
+
+// pushedSide is one side of an instrument's book kept in push form to verify checksums,
+// bids are best first by descending price and asks by ascending price
+type pushedSide struct {
+	levels []pushedLevel
+	desc   bool
+}
+
+// reset replaces the side with a snapshot's levels
+func (s *pushedSide) reset(levels [][]string) error {
+	s.levels = s.levels[:0]
+
+	return s.apply(levels)
+}
+
+// apply merges an update's levels, a zero size removes the level
+func (s *pushedSide) apply(levels [][]string) error {
+	for _, level := range levels {
+		if len(level) < 2 {
+			return fmt.Errorf("level %v has no size", level)
+		}
+
+		p, err := strconv.ParseFloat(level[0], 64)
+		if err != nil {
+			return fmt.Errorf("price %q: %w", level[0], err)
+		}
+
+		size, err := strconv.ParseFloat(level[1], 64)
+		if err != nil {
+			return fmt.Errorf("size %q: %w", level[1], err)
+		}
+
+		i, found := slices.BinarySearchFunc(s.levels, p, s.compare)
+
+		switch {
+		case size == 0 && found:
+			s.levels = slices.Delete(s.levels, i, i+1)
+		case size == 0:
+		case found:
+			s.levels[i].raw = [2]string{level[0], level[1]}
+		default:
+			s.levels = slices.Insert(s.levels, i, pushedLevel{price: p, raw: [2]string{level[0], level[1]}})
+		}
+	}
+
+	return nil
+}
+
+func (s *pushedSide) compare(l pushedLevel, p float64) int {
+	if s.desc {
+		return cmp.Compare(p, l.price)
+	}
+
+	return cmp.Compare(l.price, p)
+}
+
+// checksum is the CRC32 of the best 25 levels of each side, alternating bid and ask as
+// price:size joined by colons, a side with fewer levels leaves the rest to the other
+func checksum(bids, asks *pushedSide) int64 {
+	fields := make([]string, 0, 4*checksumLevels)
+
+	for i := 0; i < checksumLevels; i++ {
+		if i < len(bids.levels) {
+			fields = append(fields, bids.levels[i].raw[:]...)
+		}
+
+		if i < len(asks.levels) {
+			fields = append(fields, asks.levels[i].raw[:]...)
+		}
+	}
+
+	return int64(int32(crc32.ChecksumIEEE([]byte(strings.Join(fields, ":")))))
+}
@@ -0,0 +1,228 @@
+package okx
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aggregate-binance-depth/internal/metrics"
+	"github.com/aggregate-binance-depth/services"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// pingInterval keeps the connection alive, OKX closes it after 30 seconds without traffic
+const pingInterval = 20 * time.Second
+
+type BooksServiceWs struct {
+	log         *slog.Logger
+	wss         *services.WsService
+	instruments []string
+	syncs       map[string]*booksSync
+	pending     []BooksResponse
+}
+
+// BooksResponse is one push of an instrument's book, updates are only returned
+// while they continue the last snapshot
+type BooksResponse struct {
+	// Trace is the span context of the upstream read
+	Trace  trace.SpanContext
+	InstId string
+	// Snapshot replaces the book instead of changing it
+	Snapshot bool
+	Data     BooksData
+}
+
+// NewBooksServiceWs subscribes the books channel of every instrument, again after every reconnect
+func NewBooksServiceWs(l *slog.Logger, okx Okx, instruments []string, wss *services.WsService) (*BooksServiceWs, error) {
+	const op = "services.okx.booksServiceWs"
+
+	logger := l.With(slog.String("op", op))
+
+	d := &BooksServiceWs{
+		log:         l,
+		wss:         wss,
+		instruments: make([]string, 0, len(instruments)),
+		syncs:       make(map[string]*booksSync),
+	}
+
+	for _, instId := range instruments {
+		d.instruments = append(d.instruments, normalizeInstId(instId))
+	}
+
+	urls, err := okx.CreateWsUrls()
+
+	if err != nil {
+		logger.Error("error with create ws url", slog.String("error", err.Error()))
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// a new connection starts every book over from the snapshot its subscription sends
+	wss.SetOnConnect(func() error {
+		clear(d.syncs)
+
+		return d.subscribe("subscribe", d.instruments...)
+	})
+
+	if err := wss.Connect(l, urls...); err != nil {
+		logger.Error("error with init connection", slog.String("error", err.Error()))
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	go d.ping()
+
+	return d, nil
+}
+
+// ReadJSON returns the next push that keeps its book in sync
+func (d *BooksServiceWs) ReadJSON(target *BooksResponse) error {
+	for len(d.pending) == 0 {
+		if err := d.read(); err != nil {
+			return err
+		}
+	}
+
+	*target = d.pending[0]
+	d.pending = d.pending[1:]
+
+	return nil
+}
+
+func (d *BooksServiceWs) read() error {
+	const op = "services.okx.ReadJSON"
+
+	logger := d.log.With(slog.String("op", op))
+
+	sc, _, r, err := d.wss.ReadTracedMessage()
+
+	if err != nil {
+		logger.Error("error with ReadMessage", slog.String("error", err.Error()))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	var msg Message
+
+	ok, err := DecodeFrame(r, &msg)
+
+	if err != nil {
+		metrics.DecodeErrors.WithLabelValues("upstream").Inc()
+		logger.Error("error with Unmarshal", slog.String("error", err.Error()))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !ok {
+		return nil
+	}
+
+	// subscription acknowledgements and errors carry no book
+	if msg.Event == "error" {
+		logger.Error("upstream error", slog.String("code", msg.Code), slog.String("msg", msg.Msg))
+
+		return nil
+	}
+
+	if msg.Event != "" {
+		logger.Debug("upstream event", slog.String("event", msg.Event), slog.String("instId", msg.Arg.InstId))
+
+		return nil
+	}
+
+	if msg.Arg.Channel != ChannelBooks {
+		return nil
+	}
+
+	metrics.UpstreamMessages.WithLabelValues(msg.Arg.Channel + ":" + msg.Arg.InstId).Inc()
+
+	for _, data := range msg.Data {
+		d.sync(logger, sc, msg.Arg.InstId, msg.Action, data)
+	}
+
+	return nil
+}
+
+// sync queues a push when it continues the instrument's book and subscribes again when it does not
+func (d *BooksServiceWs) sync(logger *slog.Logger, sc trace.SpanContext, instId string, action string, data BooksData) {
+	s, ok := d.syncs[instId]
+	if !ok {
+		s = newBooksSync()
+		d.syncs[instId] = s
+	}
+
+	prev := s.seqId
+
+	switch s.next(action, data) {
+	case syncApply:
+		d.pending = append(d.pending, BooksResponse{Trace: sc, InstId: instId, Snapshot: action == ActionSnapshot, Data: data})
+	case syncResync:
+		logger.Warn("books update gap, resubscribing",
+			slog.String("instId", instId),
+			slog.Int64("seqId", prev),
+			slog.Int64("prevSeqId", data.PrevSeqId),
+		)
+
+		d.resync(logger, instId)
+	case syncChecksum:
+		logger.Warn("books checksum mismatch, resubscribing",
+			slog.String("instId", instId),
+			slog.Int64("seqId", data.SeqId),
+			slog.Int64("checksum", data.Checksum),
+		)
+
+		d.resync(logger, instId)
+	}
+}
+
+func (d *BooksServiceWs) resync(logger *slog.Logger, instId string) {
+	metrics.UpstreamResyncs.WithLabelValues(Venue).Inc()
+
+	if err := d.resubscribe(instId); err != nil {
+		logger.Error("error with resubscribe", slog.String("instId", instId), slog.String("error", err.Error()))
+	}
+}
+
+// resubscribe makes OKX send a new snapshot of the instrument
+func (d *BooksServiceWs) resubscribe(instId string) error {
+	if err := d.subscribe("unsubscribe", instId); err != nil {
+		return err
+	}
+
+	return d.subscribe("subscribe", instId)
+}
+
+func (d *BooksServiceWs) subscribe(op string, instIds ...string) error {
+	args := make([]Arg, 0, len(instIds))
+
+	for _, instId := range instIds {
+		args = append(args, Arg{Channel: ChannelBooks, InstId: instId})
+	}
+
+	req, err := json.Marshal(request{Op: op, Args: args})
+
+	if err != nil {
+		return err
+	}
+
+	return d.wss.WriteMessage(req)
+}
+
+// ping sends the text ping OKX expects until the service is disconnected,
+// a failed ping is left to the reader to notice
+func (d *BooksServiceWs) ping() {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.wss.Done():
+			return
+		case <-ticker.C:
+			if err := d.wss.WriteMessage([]byte("ping")); err != nil {
+				d.log.Debug("error with ping", slog.String("error", err.Error()))
+			}
+		}
+	}
+}
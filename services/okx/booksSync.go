@@ -0,0 +1,62 @@
+package okx
+
+// booksSync keeps an instrument's updates continuous with its snapshot. Every push
+// carries the seqId of the one before it in prevSeqId and the checksum of the book after it,
+// a mismatch of either means a wrong book and the channel is subscribed again to get a new snapshot.
+type booksSync struct {
+	synced bool
+	seqId  int64
+	bids   pushedSide
+	asks   pushedSide
+}
+
+func newBooksSync() *booksSync {
+	return &booksSync{bids: pushedSide{desc: true}}
+}
+
+type syncAction int
+
+const (
+	// syncApply merges the push into the book or replaces it with a snapshot
+	syncApply syncAction = iota
+	// syncDrop skips an update while no snapshot has arrived
+	syncDrop
+	// syncResync subscribes again, the update does not follow the book
+	syncResync
+	// syncChecksum subscribes again, the book after the push does not match its checksum
+	syncChecksum
+)
+
+func (s *booksSync) next(action string, d BooksData) syncAction {
+	if action == ActionSnapshot {
+		s.synced = true
+		s.seqId = d.SeqId
+
+		return s.verify(d, s.bids.reset(d.Bids), s.asks.reset(d.Asks))
+	}
+
+	if !s.synced {
+		return syncDrop
+	}
+
+	if d.PrevSeqId != s.seqId {
+		s.synced = false
+
+		return syncResync
+	}
+
+	s.seqId = d.SeqId
+
+	return s.verify(d, s.bids.apply(d.Bids), s.asks.apply(d.Asks))
+}
+
+// verify checks the book after d against its checksum, levels that failed to parse fail it too
+func (s *booksSync) verify(d BooksData, bidsErr, asksErr error) syncAction {
+	if bidsErr != nil || asksErr != nil || checksum(&s.bids, &s.asks) != d.Checksum {
+		s.synced = false
+
+		return syncChecksum
+	}
+
+	return syncApply
+}
@@ -0,0 +1,47 @@
+package okx
+
+import "testing"
+
+func TestBooksSyncVerifiesChecksums(t *testing.T) {
+	s := newBooksSync()
+
+	snapshot := BooksData{
+		Bids:      [][]string{{"3366.1", "7", "0", "3"}, {"3366", "6", "0", "4"}},
+		Asks:      [][]string{{"3366.8", "9", "0", "3"}, {"3368", "8", "0", "4"}},
+		Checksum:  -1881014294, // crc32 of 3366.1:7:3366.8:9:3366:6:3368:8
+		PrevSeqId: -1,
+		SeqId:     10,
+	}
+
+	if got := s.next(ActionSnapshot, snapshot); got != syncApply {
+		t.Fatalf("snapshot = %v, want apply", got)
+	}
+
+	// removes the best bid and adds a third ask, the book is 3366:6:3366.8:9:3368:8:3369:1
+	update := BooksData{
+		Bids:      [][]string{{"3366.1", "0", "0", "0"}},
+		Asks:      [][]string{{"3369", "1", "0", "1"}},
+		Checksum:  1203076656,
+		PrevSeqId: 10,
+		SeqId:     11,
+	}
+
+	if got := s.next(ActionUpdate, update); got != syncApply {
+		t.Fatalf("update = %v, want apply", got)
+	}
+
+	mismatch := BooksData{
+		Bids:      [][]string{{"3365", "2", "0", "1"}},
+		Checksum:  1,
+		PrevSeqId: 11,
+		SeqId:     12,
+	}
+
+	if got := s.next(ActionUpdate, mismatch); got != syncChecksum {
+		t.Fatalf("update with a wrong checksum = %v, want checksum", got)
+	}
+
+	if got := s.next(ActionUpdate, BooksData{PrevSeqId: 12, SeqId: 13}); got != syncDrop {
+		t.Errorf("update after a mismatch = %v, want drop until the next snapshot", got)
+	}
+}
@@ -0,0 +1,121 @@
+package okx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	instrumentsPath = "/api/v5/public/instruments"
+	restTimeout     = 10 * time.Second
+)
+
+// Instrument types, the type of an instrument id follows from its suffix
+const (
+	InstTypeSpot    = "SPOT"
+	InstTypeSwap    = "SWAP"
+	InstTypeFutures = "FUTURES"
+)
+
+// futuresSuffix is the delivery date of a futures instrument, e.g. BTC-USD-250328
+var futuresSuffix = regexp.MustCompile(`-\d{6}$`)
+
+// InstType is the type of an instrument id, options are not supported
+func InstType(instId string) string {
+	instId = normalizeInstId(instId)
+
+	switch {
+	case strings.HasSuffix(instId, "-SWAP"):
+		return InstTypeSwap
+	case futuresSuffix.MatchString(instId):
+		return InstTypeFutures
+	default:
+		return InstTypeSpot
+	}
+}
+
+// Instrument is the trading rules of an instrument, derivatives have no base and
+// quote currency but an underlying and a settlement currency
+type Instrument struct {
+	InstId    string `json:"instId"`
+	InstType  string `json:"instType"`
+	BaseCcy   string `json:"baseCcy"`
+	QuoteCcy  string `json:"quoteCcy"`
+	Uly       string `json:"uly"`
+	SettleCcy string `json:"settleCcy"`
+	TickSz    string `json:"tickSz"`
+	LotSz     string `json:"lotSz"`
+}
+
+type instrumentsResponse struct {
+	Code string       `json:"code"`
+	Msg  string       `json:"msg"`
+	Data []Instrument `json:"data"`
+}
+
+var restClient = &http.Client{Timeout: restTimeout}
+
+// Instruments fetches the trading rules of every instrument id, one request each
+func (o Okx) Instruments(ctx context.Context, instIds []string) ([]Instrument, error) {
+	const op = "services.okx.Instruments"
+
+	res := make([]Instrument, 0, len(instIds))
+
+	for _, instId := range instIds {
+		instId = normalizeInstId(instId)
+
+		instrument, err := o.instrument(ctx, instId)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", op, instId, err)
+		}
+
+		res = append(res, instrument)
+	}
+
+	return res, nil
+}
+
+func (o Okx) instrument(ctx context.Context, instId string) (Instrument, error) {
+	endpoint, err := url.JoinPath(o.RestBaseUrl, instrumentsPath)
+	if err != nil {
+		return Instrument{}, err
+	}
+
+	query := url.Values{}
+	query.Set("instType", InstType(instId))
+	query.Set("instId", instId)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return Instrument{}, err
+	}
+
+	res, err := restClient.Do(req)
+	if err != nil {
+		return Instrument{}, err
+	}
+	defer res.Body.Close()
+
+	var body instrumentsResponse
+
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return Instrument{}, fmt.Errorf("status %d: %w", res.StatusCode, err)
+	}
+
+	// errors come with a non zero code, sometimes on a 200
+	if res.StatusCode != http.StatusOK || body.Code != "0" {
+		return Instrument{}, fmt.Errorf("status %d: code %s: %s", res.StatusCode, body.Code, body.Msg)
+	}
+
+	if len(body.Data) == 0 {
+		return Instrument{}, fmt.Errorf("instrument not listed")
+	}
+
+	return body.Data[0], nil
+}
@@ -0,0 +1,66 @@
+package okx
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// ChannelBooks is the 400 level order book, a snapshot followed by incremental updates
+const ChannelBooks = "books"
+
+// Actions of a books push
+const (
+	ActionSnapshot = "snapshot"
+	ActionUpdate   = "update"
+)
+
+// pong is the plain text answer to a ping, it is not JSON
+var pong = []byte("pong")
+
+// Arg identifies a channel subscription
+type Arg struct {
+	Channel string `json:"channel"`
+	InstId  string `json:"instId"`
+}
+
+type request struct {
+	Op   string `json:"op"`
+	Args []Arg  `json:"args"`
+}
+
+// Message is any frame of the public channels, Event is set on subscription
+// acknowledgements and errors, pushes carry Action and Data instead
+type Message struct {
+	Event  string      `json:"event"`
+	Code   string      `json:"code"`
+	Msg    string      `json:"msg"`
+	Arg    Arg         `json:"arg"`
+	Action string      `json:"action"`
+	Data   []BooksData `json:"data"`
+}
+
+// BooksData is one snapshot or update of an instrument's book, levels are
+// [price, size, deprecated, orders] and a zero size removes the level
+type BooksData struct {
+	Asks [][]string `json:"asks"`
+	Bids [][]string `json:"bids"`
+	// Ts is milliseconds since epoch as a string
+	Ts       string `json:"ts"`
+	Checksum int64  `json:"checksum"`
+	// PrevSeqId is the SeqId of the previous push of the instrument, -1 on snapshots
+	PrevSeqId int64 `json:"prevSeqId"`
+	SeqId     int64 `json:"seqId"`
+}
+
+// DecodeFrame decodes a frame into target, ok is false for frames without a message such as pongs
+func DecodeFrame(frame []byte, target *Message) (ok bool, err error) {
+	if bytes.Equal(bytes.TrimSpace(frame), pong) {
+		return false, nil
+	}
+
+	if err := json.Unmarshal(frame, target); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,68 @@
+package okx
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Venue names OKX in book keys and capture sources
+const Venue = "okx"
+
+const publicPath = "/ws/v5/public"
+
+// publicWsHosts are the production public hosts, the AWS one is a mirror
+var publicWsHosts = []string{"wss://ws.okx.com:8443", "wss://wsaws.okx.com:8443"}
+
+const restBaseUrl = "https://www.okx.com"
+
+// Okx builds upstream urls of the public channels, a zero value points at production
+type Okx struct {
+	// WsHosts are tried in order, the next one is used when a host is unreachable
+	WsHosts     []string
+	RestBaseUrl string
+}
+
+// NewOkx keeps wsHosts and restBaseUrl when set, the production hosts otherwise
+func NewOkx(wsHosts []string, restBase string) Okx {
+	if len(wsHosts) == 0 {
+		wsHosts = publicWsHosts
+	}
+
+	if restBase == "" {
+		restBase = restBaseUrl
+	}
+
+	return Okx{WsHosts: wsHosts, RestBaseUrl: restBase}
+}
+
+// CreateWsUrls builds the public channel url on every host in failover order,
+// OKX streams are subscribed with messages after connecting
+func (o Okx) CreateWsUrls() ([]string, error) {
+	const op = "services.okx.CreateWsUrls"
+
+	hosts := o.WsHosts
+
+	if len(hosts) == 0 {
+		hosts = publicWsHosts
+	}
+
+	res := make([]string, 0, len(hosts))
+
+	for _, host := range hosts {
+		endpoint, err := url.JoinPath(host, publicPath)
+
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		res = append(res, endpoint)
+	}
+
+	return res, nil
+}
+
+// normalizeInstId uppercases an instrument id, e.g. btc-usdt is BTC-USDT
+func normalizeInstId(instId string) string {
+	return strings.ToUpper(instId)
+}
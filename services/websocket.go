@@ -1,21 +1,65 @@
 package services
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/aggregate-binance-depth/internal/metrics"
+	"github.com/aggregate-binance-depth/internal/tracing"
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = tracing.Tracer("github.com/aggregate-binance-depth/services")
+
+const (
+	reconnectMinBackoff = time.Second
+	reconnectMaxBackoff = 30 * time.Second
 )
 
 type WsService struct {
 	log             *slog.Logger
 	wsRWConnCreator wsRWConnCreator
 	conn            WsConnection
+	connects        int
+	mu              sync.Mutex
+	status          ConnectionStatus
+	recorder        FrameRecorder
+	// source names the upstream in metrics and captures, e.g. spot or okx
+	source string
+	// urls are the same streams on every upstream host in failover order, next is tried first
+	urls []string
+	next int
+	done chan struct{}
+	// onConnect runs after every connect, e.g. to subscribe streams of upstreams without stream urls
+	onConnect func() error
+	writeMu   sync.Mutex
+}
+
+// FrameRecorder receives a copy of every raw upstream frame with its receive time,
+// source tells apart services sharing a recorder
+type FrameRecorder interface {
+	Record(source string, receivedAt time.Time, frame []byte)
+}
+
+// ConnectionStatus is the state of the upstream connection reported by health endpoints
+type ConnectionStatus struct {
+	Url         string    `json:"url"`
+	Connected   bool      `json:"connected"`
+	ConnectedAt time.Time `json:"connectedAt"`
+	Reconnects  int       `json:"reconnects"`
 }
 
 type WsConnection interface {
 	ReadJSON(v interface{}) error
 	ReadMessage() (messageType int, p []byte, err error)
+	WriteMessage(messageType int, data []byte) error
 	Disconnect(l *slog.Logger) error
 }
 
@@ -30,10 +74,11 @@ func NewWsService(l *slog.Logger, wsRWConnCreator wsRWConnCreator) (*WsService,
 
 	logger.Debug("Start init ws service")
 
-	return &WsService{log: l, conn: nil, wsRWConnCreator: wsRWConnCreator}, nil
+	return &WsService{log: l, conn: nil, wsRWConnCreator: wsRWConnCreator, done: make(chan struct{})}, nil
 }
 
-func (s *WsService) Connect(l *slog.Logger, url string) error {
+// Connect opens the first reachable of urls, they are kept to reconnect after the connection fails
+func (s *WsService) Connect(l *slog.Logger, urls ...string) error {
 
 	const op = "services.websocket.Connect"
 
@@ -45,31 +90,146 @@ func (s *WsService) Connect(l *slog.Logger, url string) error {
 		return fmt.Errorf("%s: %s", op, "connection already exists")
 	}
 
-	conn, err := s.wsRWConnCreator.Connect(url)
+	if len(urls) == 0 {
+		logger.Error("no urls to connect")
 
-	s.conn = conn
+		return fmt.Errorf("%s: %s", op, "no urls to connect")
+	}
 
-	if err != nil {
-		logger.Error("error with init connection", slog.String("error", err.Error()))
+	s.urls = urls
+	s.next = 0
 
+	if err := s.connectAny(logger); err != nil {
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
 	return nil
 }
 
+// connectAny tries every url once starting from next, a failed host moves next to the following one
+func (s *WsService) connectAny(logger *slog.Logger) error {
+	errs := make([]error, 0, len(s.urls))
+
+	for range s.urls {
+		url := s.urls[s.next]
+
+		conn, err := s.wsRWConnCreator.Connect(url)
+
+		if err != nil {
+			logger.Error("error with init connection", slog.String("url", url), slog.String("error", err.Error()))
+
+			errs = append(errs, fmt.Errorf("%s: %w", url, err))
+			s.next = (s.next + 1) % len(s.urls)
+
+			continue
+		}
+
+		if s.connects > 0 {
+			metrics.UpstreamReconnects.Inc()
+		}
+
+		s.connects++
+
+		s.mu.Lock()
+		s.conn = conn
+		s.status = ConnectionStatus{Url: url, Connected: true, ConnectedAt: time.Now(), Reconnects: s.connects - 1}
+		s.mu.Unlock()
+
+		metrics.UpstreamConnected.WithLabelValues(s.source).Set(1)
+
+		if s.onConnect != nil {
+			if err := s.onConnect(); err != nil {
+				logger.Error("error with onConnect", slog.String("url", url), slog.String("error", err.Error()))
+
+				s.mu.Lock()
+				s.conn = nil
+				s.mu.Unlock()
+
+				conn.Disconnect(s.log)
+				s.setDisconnected()
+
+				errs = append(errs, fmt.Errorf("%s: %w", url, err))
+				s.next = (s.next + 1) % len(s.urls)
+
+				continue
+			}
+		}
+
+		return nil
+	}
+
+	return errors.Join(errs...)
+}
+
+// reconnect drops the failed connection and retries every host with backoff until one
+// accepts or the service is disconnected
+func (s *WsService) reconnect() error {
+	const op = "services.websocket.reconnect"
+
+	logger := s.log.With(slog.String("op", op))
+
+	s.mu.Lock()
+	failed := s.conn
+
+	select {
+	case <-s.done:
+		// Disconnect already closed the connection, the read failed because of it
+		s.mu.Unlock()
+
+		return fmt.Errorf("%s: %s", op, "service disconnected")
+	default:
+	}
+
+	s.conn = nil
+	s.mu.Unlock()
+
+	if failed != nil {
+		failed.Disconnect(s.log)
+	}
+
+	backoff := reconnectMinBackoff
+
+	for {
+		select {
+		case <-s.done:
+			return fmt.Errorf("%s: %s", op, "service disconnected")
+		case <-time.After(backoff):
+		}
+
+		if err := s.connectAny(logger); err == nil {
+			logger.Info("reconnected", slog.String("url", s.Status().Url))
+
+			return nil
+		}
+
+		backoff = min(backoff*2, reconnectMaxBackoff)
+	}
+}
+
 func (s *WsService) Disconnect() error {
 	const op = "services.websocket.Disconnect"
 
 	logger := s.log.With(slog.String("op", op))
 
-	if s.conn == nil {
+	s.mu.Lock()
+	conn := s.conn
+
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+	s.mu.Unlock()
+
+	if conn == nil {
 		logger.Error("connection not exists")
 
 		return fmt.Errorf("%s: %s", op, "connection not exists")
 	}
 
-	err := s.conn.Disconnect(s.log)
+	err := conn.Disconnect(s.log)
+
+	s.setDisconnected()
 
 	if err != nil {
 		logger.Error("error with disconnect", slog.String("error", err.Error()))
@@ -85,14 +245,18 @@ func (s *WsService) ReadJSON(target interface{}) error {
 
 	logger := s.log.With(slog.String("op", op))
 
-	if s.conn == nil {
+	s.mu.Lock()
+	conn := s.conn
+	s.mu.Unlock()
+
+	if conn == nil {
 		logger.Error("connection not exists")
 
 		return fmt.Errorf("%s: %s", op, "connection not exists")
 	}
 
 	// blocks flow until the WS is closed with
-	err := s.conn.ReadJSON(target)
+	err := conn.ReadJSON(target)
 
 	if err != nil {
 		if errors.Is(err, io.ErrUnexpectedEOF) {
@@ -108,28 +272,114 @@ func (s *WsService) ReadJSON(target interface{}) error {
 }
 
 func (s *WsService) ReadMessage() (int, []byte, error) {
+	_, t, r, err := s.ReadTracedMessage()
+
+	return t, r, err
+}
+
+// ReadTracedMessage is ReadMessage that also starts the trace of the message,
+// the returned span context is invalid when the message is not sampled
+func (s *WsService) ReadTracedMessage() (trace.SpanContext, int, []byte, error) {
 	const op = "services.websocket.ReadMessage"
 
 	logger := s.log.With(slog.String("op", op))
 
-	if s.conn == nil {
+	s.mu.Lock()
+	conn := s.conn
+	s.mu.Unlock()
+
+	if conn == nil {
 		logger.Error("connection not exists")
 
-		return -1, nil, fmt.Errorf("%s: %s", op, "connection not exists")
+		return trace.SpanContext{}, -1, nil, fmt.Errorf("%s: %s", op, "connection not exists")
 	}
 
 	// blocks flow until the WS is closed or ws get message
-	t, r, err := s.conn.ReadMessage()
+	t, r, err := conn.ReadMessage()
+	receivedAt := time.Now()
 
 	if err != nil {
 		if errors.Is(err, io.ErrUnexpectedEOF) {
 			logger.Debug("Success end ReadMessage", slog.String("error", err.Error()))
 		} else {
 			logger.Error("error with ReadMessage", slog.String("error", err.Error()))
+			s.setDisconnected()
 
-			return -1, nil, fmt.Errorf("%s: %w", op, err)
+			// a failed connection can not be read again, the error still reaches the caller
+			// so it knows updates may have been missed
+			if reconnectErr := s.reconnect(); reconnectErr != nil {
+				logger.Debug("reconnect stopped", slog.String("error", reconnectErr.Error()))
+			}
+
+			return trace.SpanContext{}, -1, nil, fmt.Errorf("%s: %w", op, err)
 		}
 	}
 
-	return t, r, nil
+	if s.recorder != nil && len(r) > 0 {
+		s.recorder.Record(s.source, receivedAt, r)
+	}
+
+	_, span := tracer.Start(context.Background(), "upstream.read", trace.WithAttributes(attribute.Int("bytes", len(r))))
+	span.End()
+
+	return span.SpanContext(), t, r, nil
+}
+
+// WriteMessage sends a text message upstream, writes are serialised
+func (s *WsService) WriteMessage(data []byte) error {
+	const op = "services.websocket.WriteMessage"
+
+	s.mu.Lock()
+	conn := s.conn
+	s.mu.Unlock()
+
+	if conn == nil {
+		return fmt.Errorf("%s: %s", op, "connection not exists")
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Done is closed once the service is disconnected for good
+func (s *WsService) Done() <-chan struct{} {
+	return s.done
+}
+
+// SetOnConnect runs fn after every connect and reconnect, before the first read of the
+// connection, a failing fn moves on to the next host. It must be set before Connect.
+func (s *WsService) SetOnConnect(fn func() error) {
+	s.onConnect = fn
+}
+
+// SetSource names the upstream in metrics and captures, it must be set before Connect
+func (s *WsService) SetSource(source string) {
+	s.source = source
+}
+
+// SetRecorder tees every frame read from now on to recorder under the service's source
+func (s *WsService) SetRecorder(recorder FrameRecorder) {
+	s.recorder = recorder
+}
+
+func (s *WsService) setDisconnected() {
+	s.mu.Lock()
+	s.status.Connected = false
+	s.mu.Unlock()
+
+	metrics.UpstreamConnected.WithLabelValues(s.source).Set(0)
+}
+
+// Status returns the current upstream connection state
+func (s *WsService) Status() ConnectionStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.status
 }
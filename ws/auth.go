@@ -0,0 +1,306 @@
+package ws
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	internalServices "github.com/aggregate-binance-depth/internal/services"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AuthModeNone   = "none"
+	AuthModeAPIKey = "apiKey"
+	AuthModeHMAC   = "hmac"
+	AuthModeJWT    = "jwt"
+)
+
+const anonymousId = "anonymous"
+
+var (
+	errMissingCredentials = errors.New("missing credentials")
+	errInvalidCredentials = errors.New("invalid credentials")
+)
+
+type AuthOptions struct {
+	// Mode is one of none, apiKey, hmac, jwt, empty means none
+	Mode    string
+	APIKeys []APIKey
+	// HMACSecret signs tokens in the hmac mode, see hmacAuthenticator
+	HMACSecret  string
+	JWKSPath    string
+	JWTIssuer   string
+	JWTAudience string
+	// AllowedOrigins is the browser Origin allowlist, "*" allows every origin,
+	// empty allows same origin only
+	AllowedOrigins []string
+}
+
+type APIKey struct {
+	Name string
+	Key  string
+	// Symbols the key may subscribe to, empty means all
+	Symbols []string
+}
+
+// principal is an authenticated downstream client
+type principal struct {
+	id      string
+	symbols map[string]struct{}
+}
+
+// authenticator checks a credential taken from the request, see credential
+type authenticator interface {
+	authenticate(raw string) (principal, error)
+}
+
+func newAuthenticator(opts AuthOptions) (authenticator, error) {
+	switch opts.Mode {
+	case "", AuthModeNone:
+		return noneAuthenticator{}, nil
+	case AuthModeAPIKey:
+		if len(opts.APIKeys) == 0 {
+			return nil, fmt.Errorf("auth mode %s requires api keys", opts.Mode)
+		}
+
+		return apiKeyAuthenticator{keys: opts.APIKeys}, nil
+	case AuthModeHMAC:
+		if opts.HMACSecret == "" {
+			return nil, fmt.Errorf("auth mode %s requires a secret", opts.Mode)
+		}
+
+		return hmacAuthenticator{secret: []byte(opts.HMACSecret)}, nil
+	case AuthModeJWT:
+		keys, err := loadJWKS(opts.JWKSPath)
+		if err != nil {
+			return nil, err
+		}
+
+		return jwtAuthenticator{keys: keys, issuer: opts.JWTIssuer, audience: opts.JWTAudience}, nil
+	}
+
+	return nil, fmt.Errorf("unknown auth mode %q", opts.Mode)
+}
+
+func newPrincipal(id string, symbols []string) principal {
+	p := principal{id: id}
+
+	if len(symbols) > 0 {
+		p.symbols = make(map[string]struct{}, len(symbols))
+
+		for _, s := range symbols {
+			p.symbols[internalServices.NormalizeBookKey(s)] = struct{}{}
+		}
+	}
+
+	return p
+}
+
+// allows reports whether the principal may read symbol
+func (p principal) allows(symbol string) bool {
+	if p.symbols == nil {
+		return true
+	}
+
+	_, ok := p.symbols[internalServices.NormalizeBookKey(symbol)]
+
+	return ok
+}
+
+// restrict checks requested symbols against the allowed ones, no request means every allowed symbol
+func (p principal) restrict(requested []string) ([]string, error) {
+	if p.symbols == nil {
+		return requested, nil
+	}
+
+	if len(requested) == 0 {
+		res := make([]string, 0, len(p.symbols))
+
+		for s := range p.symbols {
+			res = append(res, s)
+		}
+
+		return res, nil
+	}
+
+	for _, s := range requested {
+		if _, ok := p.symbols[internalServices.NormalizeBookKey(s)]; !ok {
+			return nil, fmt.Errorf("symbol %s is not allowed", s)
+		}
+	}
+
+	return requested, nil
+}
+
+// credential takes a bearer token, an X-API-Key header or the token query,
+// the latter because browsers cannot set headers on websocket requests
+func credential(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimPrefix(h, "Bearer ")
+	}
+
+	if h := r.Header.Get("X-API-Key"); h != "" {
+		return h
+	}
+
+	return r.URL.Query().Get("token")
+}
+
+type noneAuthenticator struct{}
+
+func (noneAuthenticator) authenticate(raw string) (principal, error) {
+	return principal{id: anonymousId}, nil
+}
+
+type apiKeyAuthenticator struct {
+	keys []APIKey
+}
+
+func (a apiKeyAuthenticator) authenticate(raw string) (principal, error) {
+	if raw == "" {
+		return principal{}, errMissingCredentials
+	}
+
+	for _, key := range a.keys {
+		if subtle.ConstantTimeCompare([]byte(key.Key), []byte(raw)) == 1 {
+			return newPrincipal(key.Name, key.Symbols), nil
+		}
+	}
+
+	return principal{}, errInvalidCredentials
+}
+
+// hmacAuthenticator accepts tokens of the form base64url(claims).base64url(HMAC-SHA256(claims)),
+// claims being JSON {"sub": "client", "exp": unix seconds, "symbols": ["btcusdt"]}
+type hmacAuthenticator struct {
+	secret []byte
+}
+
+type hmacClaims struct {
+	Subject string   `json:"sub"`
+	Expiry  int64    `json:"exp"`
+	Symbols []string `json:"symbols"`
+}
+
+func (a hmacAuthenticator) authenticate(raw string) (principal, error) {
+	if raw == "" {
+		return principal{}, errMissingCredentials
+	}
+
+	payload, signature, ok := strings.Cut(raw, ".")
+	if !ok {
+		return principal{}, errInvalidCredentials
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return principal{}, errInvalidCredentials
+	}
+
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte(payload))
+
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return principal{}, errInvalidCredentials
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return principal{}, errInvalidCredentials
+	}
+
+	var claims hmacClaims
+
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return principal{}, errInvalidCredentials
+	}
+
+	if claims.Expiry == 0 || time.Now().Unix() >= claims.Expiry {
+		return principal{}, fmt.Errorf("token expired")
+	}
+
+	return newPrincipal(claims.Subject, claims.Symbols), nil
+}
+
+type jwtAuthenticator struct {
+	keys     jwks
+	issuer   string
+	audience string
+}
+
+type jwtClaims struct {
+	jwt.RegisteredClaims
+	Symbols []string `json:"symbols"`
+}
+
+func (a jwtAuthenticator) authenticate(raw string) (principal, error) {
+	if raw == "" {
+		return principal{}, errMissingCredentials
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(a.keys.algorithms()),
+		jwt.WithExpirationRequired(),
+	}
+
+	if a.issuer != "" {
+		opts = append(opts, jwt.WithIssuer(a.issuer))
+	}
+
+	if a.audience != "" {
+		opts = append(opts, jwt.WithAudience(a.audience))
+	}
+
+	var claims jwtClaims
+
+	if _, err := jwt.ParseWithClaims(raw, &claims, a.keys.keyfunc, opts...); err != nil {
+		return principal{}, fmt.Errorf("%w: %w", errInvalidCredentials, err)
+	}
+
+	return newPrincipal(claims.Subject, claims.Symbols), nil
+}
+
+// checkOrigin builds the upgrader origin check, requests without Origin are not from browsers and pass
+func checkOrigin(allowed []string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+
+		if origin == "" {
+			return true
+		}
+
+		if len(allowed) == 0 {
+			u, err := url.Parse(origin)
+
+			return err == nil && strings.EqualFold(u.Host, r.Host)
+		}
+
+		for _, o := range allowed {
+			if o == "*" || strings.EqualFold(o, origin) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// AuthenticateCredential checks a credential the way the streams do, allows reports the symbols
+// its owner may read. The gRPC server authenticates calls with it.
+func (ws *WebsocketServer) AuthenticateCredential(credential string) (func(symbol string) bool, error) {
+	p, err := ws.auth.authenticate(credential)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.allows, nil
+}
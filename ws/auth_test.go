@@ -0,0 +1,100 @@
+package ws
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	internalServices "github.com/aggregate-binance-depth/internal/services"
+)
+
+// booksGate serves a one level book of every symbol
+type booksGate struct {
+	stubGate
+	symbols []string
+}
+
+func (g booksGate) Book(symbol string, levels int) (internalServices.BookSnapshot, bool) {
+	symbol = internalServices.NormalizeBookKey(symbol)
+
+	for _, s := range g.symbols {
+		if s == symbol {
+			return internalServices.BookSnapshot{Symbol: s, Bids: []internalServices.PriceLevel{{Price: 1, Quantity: 1}}}, true
+		}
+	}
+
+	return internalServices.BookSnapshot{}, false
+}
+
+func (g booksGate) Symbols() []internalServices.SymbolStatus {
+	res := make([]internalServices.SymbolStatus, 0, len(g.symbols))
+
+	for _, s := range g.symbols {
+		res = append(res, internalServices.SymbolStatus{Symbol: s, Status: internalServices.SymbolStatusLive})
+	}
+
+	return res
+}
+
+func TestRestRequiresAllowedSymbols(t *testing.T) {
+	opts := Options{Auth: AuthOptions{
+		Mode:    AuthModeAPIKey,
+		APIKeys: []APIKey{{Name: "btc", Key: "secret", Symbols: []string{"btcusdt"}}},
+	}}
+
+	_, srv := newTestServer(t, opts, booksGate{symbols: []string{"BTCUSDT", "ETHUSDT"}})
+
+	tests := []struct {
+		path string
+		key  string
+		want int
+	}{
+		{path: "/depth/btcusdt", want: http.StatusUnauthorized},
+		{path: "/depth/btcusdt", key: "wrong", want: http.StatusUnauthorized},
+		{path: "/depth/btcusdt", key: "secret", want: http.StatusOK},
+		{path: "/depth/binance:BTCUSDT", key: "secret", want: http.StatusOK},
+		{path: "/depth/ethusdt", key: "secret", want: http.StatusForbidden},
+		{path: "/depth/ethusdt/liquidity?bps=10", key: "secret", want: http.StatusForbidden},
+		{path: "/bars/ethusdt", key: "secret", want: http.StatusForbidden},
+		{path: "/symbols", want: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+tt.path, nil)
+
+		if tt.key != "" {
+			req.Header.Set("X-API-Key", tt.key)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp.Body.Close()
+
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s with key %q = %d, want %d", tt.path, tt.key, resp.StatusCode, tt.want)
+		}
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/symbols", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	var symbols []internalServices.SymbolStatus
+
+	if err := json.NewDecoder(resp.Body).Decode(&symbols); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(symbols) != 1 || symbols[0].Symbol != "BTCUSDT" {
+		t.Fatalf("/symbols = %v, want only BTCUSDT", symbols)
+	}
+}
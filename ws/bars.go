@@ -0,0 +1,151 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	internalServices "github.com/aggregate-binance-depth/internal/services"
+)
+
+// Channels a client picks with the channels query, e.g. ?channels=depth,bars.
+// Without it a client only gets the depth channel.
+const (
+	channelDepth = "depth"
+	channelBars  = "bars"
+)
+
+const (
+	defaultBarsLimit = 100
+	maxBarsLimit     = 10000
+)
+
+var (
+	errBinaryBars     = errors.New("bars are not available in the binary encoding")
+	errInvalidLimit   = fmt.Errorf("limit must be an integer between 1 and %d", maxBarsLimit)
+	errInvalidChannel = fmt.Errorf("channels must be %s or %s", channelDepth, channelBars)
+)
+
+// barHistory is the ring buffer of closed bars served on /bars
+type barHistory interface {
+	History(symbol string, interval time.Duration, limit int) ([]internalServices.Bar, error)
+	Intervals() []time.Duration
+}
+
+// RegisterBars serves the bars history, without it /bars is not found
+func (ws *WebsocketServer) RegisterBars(bars barHistory) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	ws.bars = bars
+}
+
+// WriteBar sends a closed bar to the clients of the bars channel subscribed to its symbol
+func (ws *WebsocketServer) WriteBar(ctx context.Context, bar internalServices.Bar) error {
+	const op = "services.ws.WriteBar"
+
+	logger := ws.log.With(slog.String("op", op))
+
+	if err := ws.broadcast(ctx, logger, eventBar, bar.Symbol, bar); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// handleBars serves the latest closed bars of a symbol, e.g. /bars/btcusdt?interval=1m&limit=60,
+// interval defaults to the shortest configured one
+func (ws *WebsocketServer) handleBars(w http.ResponseWriter, r *http.Request) {
+	if ws.bars == nil {
+		ws.writeResponse(w, http.StatusNotFound, errorResponse{Error: "bars are not enabled"})
+
+		return
+	}
+
+	interval := ws.bars.Intervals()[0]
+
+	if raw := r.URL.Query().Get("interval"); raw != "" {
+		var err error
+
+		if interval, err = time.ParseDuration(raw); err != nil {
+			ws.writeResponse(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+
+			return
+		}
+	}
+
+	limit, err := parseBarsLimit(r)
+	if err != nil {
+		ws.writeResponse(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+
+		return
+	}
+
+	if !ws.tracks(r.PathValue("symbol")) {
+		ws.writeResponse(w, http.StatusNotFound, errorResponse{Error: errSymbolNotFound.Error()})
+
+		return
+	}
+
+	bars, err := ws.bars.History(r.PathValue("symbol"), interval, limit)
+	if err != nil {
+		ws.writeResponse(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("%s: %s", internalServices.FormatInterval(interval), err)})
+
+		return
+	}
+
+	ws.writeResponse(w, http.StatusOK, bars)
+}
+
+// tracks reports whether the gate tracks symbol in any spelling
+func (ws *WebsocketServer) tracks(symbol string) bool {
+	symbol = internalServices.NormalizeBookKey(symbol)
+
+	for _, status := range ws.depthGateService.Symbols() {
+		if status.Symbol == symbol {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseBarsLimit(r *http.Request) (int, error) {
+	raw := r.URL.Query().Get("limit")
+	if raw == "" {
+		return defaultBarsLimit, nil
+	}
+
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit <= 0 || limit > maxBarsLimit {
+		return 0, errInvalidLimit
+	}
+
+	return limit, nil
+}
+
+// parseChannels reads the comma separated channels query, depth when absent
+func parseChannels(r *http.Request) ([]string, error) {
+	raw := r.URL.Query().Get("channels")
+	if raw == "" {
+		return []string{channelDepth}, nil
+	}
+
+	res := make([]string, 0, 2)
+
+	for _, channel := range strings.Split(raw, ",") {
+		switch channel = strings.TrimSpace(channel); channel {
+		case channelDepth, channelBars:
+			res = append(res, channel)
+		default:
+			return nil, errInvalidChannel
+		}
+	}
+
+	return res, nil
+}
@@ -0,0 +1,105 @@
+package ws
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	internalServices "github.com/aggregate-binance-depth/internal/services"
+	"github.com/gorilla/websocket"
+)
+
+// stubGate serves no books, methods a test does not override panic through the nil interface
+type stubGate struct {
+	depthGateService
+}
+
+func (stubGate) CurrentDepths() []internalServices.DepthWriterRequest {
+	return nil
+}
+
+func newTestServer(tb testing.TB, opts Options, gate depthGateService) (*WebsocketServer, *httptest.Server) {
+	tb.Helper()
+
+	ws, err := NewWebsocketServer(slog.New(slog.NewTextHandler(io.Discard, nil)), opts)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	ws.RegisterDepthGateService(gate)
+
+	srv := httptest.NewServer(ws.newMux())
+
+	tb.Cleanup(func() {
+		ws.closeClients()
+		srv.Close()
+	})
+
+	return ws, srv
+}
+
+// dialClients connects n websocket clients that count down received on every depth update,
+// the snapshot each client gets on connect is read before dialClients returns
+func dialClients(tb testing.TB, srv *httptest.Server, enc encoding, n int, received *sync.WaitGroup) {
+	tb.Helper()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws?encoding=" + string(enc)
+
+	for i := 0; i < n; i++ {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			tb.Fatal(err)
+		}
+
+		tb.Cleanup(func() { conn.Close() })
+
+		if _, _, err := conn.ReadMessage(); err != nil {
+			tb.Fatal(err)
+		}
+
+		go func() {
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+
+				received.Done()
+			}
+		}()
+	}
+}
+
+// BenchmarkBroadcast measures an update from WriteJSON until every client has read it
+func BenchmarkBroadcast(b *testing.B) {
+	depth := internalServices.DepthWriterRequest{Symbol: "BTCUSDT", Bid: 50000.1, Ask: 50000.2, BidQty: 1.5, AskQty: 2}
+
+	for _, enc := range encodings {
+		for _, clients := range []int{1, 100, 1000} {
+			b.Run(fmt.Sprintf("%s/%d", enc, clients), func(b *testing.B) {
+				ws, srv := newTestServer(b, Options{}, stubGate{})
+
+				var received sync.WaitGroup
+
+				dialClients(b, srv, enc, clients, &received)
+
+				b.ReportAllocs()
+				b.ResetTimer()
+
+				for i := 0; i < b.N; i++ {
+					received.Add(clients)
+
+					if err := ws.WriteJSON(context.Background(), depth); err != nil {
+						b.Fatal(err)
+					}
+
+					received.Wait()
+				}
+			})
+		}
+	}
+}
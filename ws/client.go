@@ -0,0 +1,157 @@
+package ws
+
+import (
+	"sync"
+
+	internalServices "github.com/aggregate-binance-depth/internal/services"
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
+)
+
+const (
+	clientSendBuffer = 256
+
+	eventSnapshot = "snapshot"
+	eventDepth    = "depth"
+	eventBar      = "bar"
+)
+
+// message is a serialised update queued for a client, websocket clients
+// get prepared set so the frame is built once for all of them
+type message struct {
+	id       uint64
+	event    string
+	symbol   string
+	data     []byte
+	prepared *websocket.PreparedMessage
+	// control replies are JSON text frames, a non zero closeCode disconnects after the write
+	control   bool
+	closeCode int
+	// trace is the span context of the broadcast, invalid when the update is not sampled
+	trace trace.SpanContext
+}
+
+// frame is a payload encoded once per encoding and shared by every client using it
+type frame struct {
+	data     []byte
+	prepared *websocket.PreparedMessage
+	err      error
+}
+
+type frames map[encoding]*frame
+
+// forClient returns the frame in the client's encoding, encoding and preparing it on first use
+func (fs frames) forClient(c *client, payload any) (*frame, error) {
+	f, ok := fs[c.encoding]
+	if !ok {
+		f = &frame{}
+		f.data, f.err = c.encoding.encode(payload)
+		fs[c.encoding] = f
+	}
+
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	if c.conn != nil && f.prepared == nil {
+		if f.prepared, f.err = websocket.NewPreparedMessage(c.encoding.messageType(), f.data); f.err != nil {
+			return nil, f.err
+		}
+	}
+
+	return f, nil
+}
+
+// client is a downstream consumer fed by the broadcast, conn is nil for SSE clients
+type client struct {
+	id        id
+	conn      *websocket.Conn
+	principal principal
+	ip        string
+	encoding  encoding
+	// symbols is guarded by WebsocketServer.mu, nil means every symbol
+	symbols map[string]struct{}
+	// channels are the kinds of events the client gets, see parseChannels
+	channels  map[string]struct{}
+	limiter   *rate.Limiter
+	send      chan message
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newClient(clientId id, conn *websocket.Conn, p principal, ip string, enc encoding, symbols []string, channels []string) *client {
+	c := &client{
+		id:        clientId,
+		conn:      conn,
+		principal: p,
+		ip:        ip,
+		encoding:  enc,
+		channels:  make(map[string]struct{}, len(channels)),
+		send:      make(chan message, clientSendBuffer),
+		done:      make(chan struct{}),
+	}
+
+	for _, channel := range channels {
+		c.channels[channel] = struct{}{}
+	}
+
+	if len(symbols) > 0 {
+		c.symbols = make(map[string]struct{}, len(symbols))
+
+		for _, s := range symbols {
+			c.symbols[internalServices.NormalizeBookKey(s)] = struct{}{}
+		}
+	}
+
+	return c
+}
+
+// wants reports whether the client is subscribed to the symbol, clients without filter get everything
+func (c *client) wants(symbol string) bool {
+	if c.symbols == nil || symbol == "" {
+		return true
+	}
+
+	_, ok := c.symbols[symbol]
+
+	return ok
+}
+
+// listens reports whether the client's channels carry the event, snapshots go with the depth channel
+func (c *client) listens(event string) bool {
+	channel := channelDepth
+
+	if event == eventBar {
+		channel = channelBars
+	}
+
+	_, ok := c.channels[channel]
+
+	return ok
+}
+
+// transport labels the client in metrics
+func (c *client) transport() string {
+	if c.conn == nil {
+		return "sse"
+	}
+
+	return "ws"
+}
+
+// enqueue never blocks the broadcast, a slow client loses the message instead
+func (c *client) enqueue(m message) bool {
+	select {
+	case c.send <- m:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *client) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+}
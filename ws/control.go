@@ -0,0 +1,159 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+
+	internalServices "github.com/aggregate-binance-depth/internal/services"
+)
+
+// Control messages are JSON text frames whatever encoding the client chose:
+//
+//	-> {"method": "subscribe", "symbols": ["btcusdt"]}
+//	<- {"type": "subscriptions", "symbols": ["BTCUSDT"]}
+//	<- {"type": "error", "code": "rate_limited", "message": "..."}
+//
+// A connection opened without symbols receives every symbol until its first subscribe.
+const (
+	methodSubscribe   = "subscribe"
+	methodUnsubscribe = "unsubscribe"
+
+	responseSubscriptions = "subscriptions"
+	responseError         = "error"
+)
+
+type controlRequest struct {
+	Method  string   `json:"method"`
+	Symbols []string `json:"symbols"`
+}
+
+type controlResponse struct {
+	Type    string   `json:"type"`
+	Symbols []string `json:"symbols,omitempty"`
+	Code    string   `json:"code,omitempty"`
+	Message string   `json:"message,omitempty"`
+}
+
+func errorResponseOf(code string, err error) controlResponse {
+	return controlResponse{Type: responseError, Code: code, Message: err.Error()}
+}
+
+// handleControl applies a control message, a returned limitError means the client must be disconnected
+func (ws *WebsocketServer) handleControl(c *client, data []byte) (controlResponse, error) {
+	var req controlRequest
+
+	if err := json.Unmarshal(data, &req); err != nil {
+		return errorResponseOf("invalid_request", err), nil
+	}
+
+	if len(req.Symbols) == 0 {
+		return errorResponseOf("invalid_request", errors.New("symbols are required")), nil
+	}
+
+	switch req.Method {
+	case methodSubscribe:
+		symbols, err := c.principal.restrict(req.Symbols)
+		if err != nil {
+			return errorResponseOf("symbol_not_allowed", err), nil
+		}
+
+		return ws.subscribe(c, symbols)
+	case methodUnsubscribe:
+		return ws.unsubscribe(c, req.Symbols), nil
+	}
+
+	return errorResponseOf("invalid_request", errors.New("unknown method "+req.Method)), nil
+}
+
+func (ws *WebsocketServer) subscribe(c *client, symbols []string) (controlResponse, error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	next := make(map[string]struct{}, len(c.symbols)+len(symbols))
+
+	for s := range c.symbols {
+		next[s] = struct{}{}
+	}
+
+	added := make(map[string]struct{}, len(symbols))
+
+	for _, s := range symbols {
+		s = internalServices.NormalizeBookKey(s)
+
+		if _, ok := next[s]; !ok {
+			added[s] = struct{}{}
+		}
+
+		next[s] = struct{}{}
+	}
+
+	if err := ws.checkSubscriptions(len(next)); err != nil {
+		return controlResponse{}, err
+	}
+
+	// a client that got every symbol already holds the snapshot of the added ones
+	hadAll := c.symbols == nil
+	c.symbols = next
+
+	if !hadAll && len(added) > 0 && c.listens(eventSnapshot) {
+		ws.enqueueSnapshot(c, func(s string) bool {
+			_, ok := added[s]
+
+			return ok
+		})
+	}
+
+	return controlResponse{Type: responseSubscriptions, Symbols: sortedSymbols(next)}, nil
+}
+
+func (ws *WebsocketServer) unsubscribe(c *client, symbols []string) controlResponse {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if c.symbols == nil {
+		c.symbols = make(map[string]struct{})
+
+		for _, status := range ws.depthGateService.Symbols() {
+			c.symbols[status.Symbol] = struct{}{}
+		}
+	}
+
+	for _, s := range symbols {
+		delete(c.symbols, internalServices.NormalizeBookKey(s))
+	}
+
+	return controlResponse{Type: responseSubscriptions, Symbols: sortedSymbols(c.symbols)}
+}
+
+// enqueueSnapshot queues current depths matching filter, must be called with ws.mu held
+func (ws *WebsocketServer) enqueueSnapshot(c *client, filter func(symbol string) bool) error {
+	snapshot := make([]internalServices.DepthWriterRequest, 0)
+
+	for _, depth := range ws.depthGateService.CurrentDepths() {
+		if filter(depth.Symbol) {
+			snapshot = append(snapshot, depth)
+		}
+	}
+
+	data, err := c.encoding.encode(snapshot)
+	if err != nil {
+		return err
+	}
+
+	c.enqueue(message{id: ws.seq, event: eventSnapshot, data: data})
+
+	return nil
+}
+
+func sortedSymbols(symbols map[string]struct{}) []string {
+	res := make([]string, 0, len(symbols))
+
+	for s := range symbols {
+		res = append(res, s)
+	}
+
+	sort.Strings(res)
+
+	return res
+}
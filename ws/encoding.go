@@ -0,0 +1,179 @@
+package ws
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+
+	internalServices "github.com/aggregate-binance-depth/internal/services"
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+type encoding string
+
+const (
+	encodingJSON    encoding = "json"
+	encodingMsgpack encoding = "msgpack"
+	encodingBinary  encoding = "binary"
+
+	subprotocolPrefix = "depth."
+)
+
+// Compact binary layout, all numbers little endian:
+//
+//	depth:    [1]type=1 record
+//	snapshot: [1]type=2 [2]count uint16, then count records
+//	record:   [1]length uint8 [length]symbol [8]bid float64 [8]ask float64 [8]bidQty float64 [8]askQty float64
+//	          [1]length uint8 [length]bidVenue [1]length uint8 [length]askVenue
+//
+// strings are ASCII, symbols include venue qualified and consolidated keys such as OKX:BTC-USDT-SWAP
+// or NBBO:BTC-USDT. The venues are empty except for consolidated symbols.
+const (
+	binaryTypeDepth    byte = 1
+	binaryTypeSnapshot byte = 2
+
+	// binaryRecordSize is a record without its strings
+	binaryRecordSize = 1 + 8*4 + 1 + 1
+)
+
+var encodings = []encoding{encodingJSON, encodingMsgpack, encodingBinary}
+
+// subprotocols lists what the upgrader accepts, e.g. "depth.msgpack"
+func subprotocols() []string {
+	res := make([]string, 0, len(encodings))
+
+	for _, e := range encodings {
+		res = append(res, subprotocolPrefix+string(e))
+	}
+
+	return res
+}
+
+// negotiateEncoding prefers the negotiated subprotocol, then the encoding query, then JSON
+func negotiateEncoding(conn *websocket.Conn, r *http.Request) (encoding, error) {
+	if p := conn.Subprotocol(); p != "" {
+		return parseEncoding(p[len(subprotocolPrefix):])
+	}
+
+	if raw := r.URL.Query().Get("encoding"); raw != "" {
+		return parseEncoding(raw)
+	}
+
+	return encodingJSON, nil
+}
+
+func parseEncoding(raw string) (encoding, error) {
+	for _, e := range encodings {
+		if string(e) == raw {
+			return e, nil
+		}
+	}
+
+	return "", fmt.Errorf("unsupported encoding %q", raw)
+}
+
+// messageType is the websocket frame type the encoding is sent with
+func (e encoding) messageType() int {
+	if e == encodingJSON {
+		return websocket.TextMessage
+	}
+
+	return websocket.BinaryMessage
+}
+
+// encode serialises a DepthWriterRequest or a snapshot slice of them
+func (e encoding) encode(payload any) ([]byte, error) {
+	switch e {
+	case encodingJSON:
+		return json.Marshal(payload)
+	case encodingMsgpack:
+		var buf bytes.Buffer
+
+		enc := msgpack.NewEncoder(&buf)
+		enc.SetCustomStructTag("json")
+
+		if err := enc.Encode(payload); err != nil {
+			return nil, err
+		}
+
+		return buf.Bytes(), nil
+	case encodingBinary:
+		return encodeBinary(payload)
+	}
+
+	return nil, fmt.Errorf("unsupported encoding %q", e)
+}
+
+func encodeBinary(payload any) ([]byte, error) {
+	switch v := payload.(type) {
+	case internalServices.DepthWriterRequest:
+		buf := make([]byte, 1, 1+binaryRecordLen(v))
+		buf[0] = binaryTypeDepth
+
+		return appendBinaryRecord(buf, v)
+	case []internalServices.DepthWriterRequest:
+		if len(v) > math.MaxUint16 {
+			return nil, fmt.Errorf("snapshot too large: %d records", len(v))
+		}
+
+		size := 3
+
+		for _, depth := range v {
+			size += binaryRecordLen(depth)
+		}
+
+		buf := make([]byte, 3, size)
+		buf[0] = binaryTypeSnapshot
+		binary.LittleEndian.PutUint16(buf[1:3], uint16(len(v)))
+
+		for _, depth := range v {
+			var err error
+
+			if buf, err = appendBinaryRecord(buf, depth); err != nil {
+				return nil, err
+			}
+		}
+
+		return buf, nil
+	}
+
+	return nil, fmt.Errorf("unsupported binary payload %T", payload)
+}
+
+func binaryRecordLen(depth internalServices.DepthWriterRequest) int {
+	return binaryRecordSize + len(depth.Symbol) + len(depth.BidVenue) + len(depth.AskVenue)
+}
+
+func appendBinaryRecord(buf []byte, depth internalServices.DepthWriterRequest) ([]byte, error) {
+	var err error
+
+	if buf, err = appendBinaryString(buf, depth.Symbol); err != nil {
+		return nil, err
+	}
+
+	buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(depth.Bid))
+	buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(depth.Ask))
+	buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(depth.BidQty))
+	buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(depth.AskQty))
+
+	if buf, err = appendBinaryString(buf, depth.BidVenue); err != nil {
+		return nil, err
+	}
+
+	return appendBinaryString(buf, depth.AskVenue)
+}
+
+// appendBinaryString appends s prefixed with its length
+func appendBinaryString(buf []byte, s string) ([]byte, error) {
+	if len(s) > math.MaxUint8 {
+		return nil, fmt.Errorf("%q is longer than %d bytes", s, math.MaxUint8)
+	}
+
+	buf = append(buf, byte(len(s)))
+
+	return append(buf, s...), nil
+}
@@ -0,0 +1,116 @@
+package ws
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	internalServices "github.com/aggregate-binance-depth/internal/services"
+	"github.com/gorilla/websocket"
+)
+
+// depthsGate serves fixed tops of book
+type depthsGate struct {
+	depthGateService
+	depths []internalServices.DepthWriterRequest
+}
+
+func (g depthsGate) CurrentDepths() []internalServices.DepthWriterRequest {
+	return g.depths
+}
+
+// decodeBinaryString reads one length prefixed string off data and returns the rest
+func decodeBinaryString(t *testing.T, data []byte) (string, []byte) {
+	t.Helper()
+
+	if len(data) < 1 || len(data) < 1+int(data[0]) {
+		t.Fatalf("string % x is truncated", data)
+	}
+
+	n := int(data[0])
+
+	return string(data[1 : 1+n]), data[1+n:]
+}
+
+// decodeBinaryRecord reads one record off data and returns the rest
+func decodeBinaryRecord(t *testing.T, data []byte) (internalServices.DepthWriterRequest, []byte) {
+	t.Helper()
+
+	var res internalServices.DepthWriterRequest
+
+	res.Symbol, data = decodeBinaryString(t, data)
+
+	if len(data) < 32 {
+		t.Fatalf("record % x is truncated", data)
+	}
+
+	res.Bid = math.Float64frombits(binary.LittleEndian.Uint64(data[0:8]))
+	res.Ask = math.Float64frombits(binary.LittleEndian.Uint64(data[8:16]))
+	res.BidQty = math.Float64frombits(binary.LittleEndian.Uint64(data[16:24]))
+	res.AskQty = math.Float64frombits(binary.LittleEndian.Uint64(data[24:32]))
+
+	res.BidVenue, data = decodeBinaryString(t, data[32:])
+	res.AskVenue, data = decodeBinaryString(t, data)
+
+	return res, data
+}
+
+func TestBinaryClientWithLongKeys(t *testing.T) {
+	swap := internalServices.DepthWriterRequest{Symbol: "OKX:BTC-USDT-SWAP", Bid: 50000.1, Ask: 50000.2, BidQty: 1, AskQty: 2}
+	gate := depthsGate{depths: []internalServices.DepthWriterRequest{
+		{Symbol: "BTCUSDT", Bid: 50000, Ask: 50000.5, BidQty: 0.5, AskQty: 0.25},
+		swap,
+		{Symbol: "NBBO:BTC-USDT-SWAP", Bid: 50000.1, Ask: 50000.5, BidQty: 1, AskQty: 0.25, BidVenue: "okx", AskVenue: "binance"},
+	}}
+
+	ws, srv := newTestServer(t, Options{}, gate)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws?encoding=binary", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data[0] != binaryTypeSnapshot || binary.LittleEndian.Uint16(data[1:3]) != 3 {
+		t.Fatalf("snapshot header = % x, want 3 records", data[:3])
+	}
+
+	rest := data[3:]
+
+	for _, want := range gate.depths {
+		var got internalServices.DepthWriterRequest
+
+		if got, rest = decodeBinaryRecord(t, rest); !reflect.DeepEqual(got, want) {
+			t.Errorf("snapshot record = %+v, want %+v", got, want)
+		}
+	}
+
+	if len(rest) != 0 {
+		t.Errorf("snapshot has % x left after its records", rest)
+	}
+
+	if err := ws.WriteJSON(context.Background(), swap); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, data, err = conn.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+
+	if data[0] != binaryTypeDepth {
+		t.Fatalf("type = %d, want a depth update", data[0])
+	}
+
+	if got, _ := decodeBinaryRecord(t, data[1:]); !reflect.DeepEqual(got, swap) {
+		t.Errorf("update = %+v, want %+v", got, swap)
+	}
+}
@@ -0,0 +1,111 @@
+package ws
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	internalServices "github.com/aggregate-binance-depth/internal/services"
+	"github.com/aggregate-binance-depth/services"
+)
+
+type upstreamShard interface {
+	Status() services.ConnectionStatus
+}
+
+type shardStatus struct {
+	Id int `json:"id"`
+	services.ConnectionStatus
+	UptimeSeconds float64 `json:"uptimeSeconds"`
+}
+
+type readiness struct {
+	Ready   bool     `json:"ready"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+type statusResponse struct {
+	readiness
+	Shards  []shardStatus                   `json:"shards"`
+	Symbols []internalServices.SymbolStatus `json:"symbols"`
+}
+
+// RegisterShard adds an upstream connection to readiness and status reports
+func (ws *WebsocketServer) RegisterShard(shard upstreamShard) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	ws.shards = append(ws.shards, shard)
+}
+
+func (ws *WebsocketServer) registerHealthHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("GET /healthz", ws.handleHealthz)
+	mux.HandleFunc("GET /readyz", ws.handleReadyz)
+	mux.HandleFunc("GET /status", ws.handleStatus)
+}
+
+// handleHealthz only proves the process serves requests
+func (ws *WebsocketServer) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	ws.writeResponse(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (ws *WebsocketServer) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	res := ws.status()
+
+	ws.writeResponse(w, readinessCode(res.readiness), res.readiness)
+}
+
+func (ws *WebsocketServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	ws.writeResponse(w, http.StatusOK, ws.status())
+}
+
+// status is ready when every shard is connected and every configured symbol has a live book
+func (ws *WebsocketServer) status() statusResponse {
+	ws.mu.Lock()
+	shards := make([]upstreamShard, len(ws.shards))
+	copy(shards, ws.shards)
+	ws.mu.Unlock()
+
+	now := time.Now()
+
+	res := statusResponse{
+		Shards:  make([]shardStatus, 0, len(shards)),
+		Symbols: ws.depthGateService.Symbols(),
+	}
+
+	res.Reasons = make([]string, 0)
+
+	if len(shards) == 0 {
+		res.Reasons = append(res.Reasons, "no upstream connections")
+	}
+
+	for i, shard := range shards {
+		st := shardStatus{Id: i, ConnectionStatus: shard.Status()}
+
+		if st.Connected {
+			st.UptimeSeconds = now.Sub(st.ConnectedAt).Seconds()
+		} else {
+			res.Reasons = append(res.Reasons, fmt.Sprintf("shard %d is disconnected", i))
+		}
+
+		res.Shards = append(res.Shards, st)
+	}
+
+	for _, symbol := range res.Symbols {
+		if symbol.Status != internalServices.SymbolStatusLive {
+			res.Reasons = append(res.Reasons, fmt.Sprintf("symbol %s is %s", symbol.Symbol, symbol.Status))
+		}
+	}
+
+	res.Ready = len(res.Reasons) == 0
+
+	return res
+}
+
+func readinessCode(r readiness) int {
+	if r.Ready {
+		return http.StatusOK
+	}
+
+	return http.StatusServiceUnavailable
+}
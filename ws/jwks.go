@@ -0,0 +1,179 @@
+package ws
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jwks map[string]crypto.PublicKey
+
+// loadJWKS reads RSA, EC and Ed25519 public keys from a local JWKS file
+func loadJWKS(path string) (jwks, error) {
+	const op = "ws.jwks.loadJWKS"
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	res := make(jwks, len(set.Keys))
+
+	for _, k := range set.Keys {
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("%s: key %q: %w", op, k.Kid, err)
+		}
+
+		res[k.Kid] = key
+	}
+
+	if len(res) == 0 {
+		return nil, fmt.Errorf("%s: %s", op, "no keys in jwks")
+	}
+
+	return res, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid ed25519 key size %d", len(x))
+		}
+
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeBigInt(raw string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(data), nil
+}
+
+// keyfunc picks the key by kid, a token without kid is accepted only when the set has one key
+func (keys jwks) keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key, nil
+		}
+	}
+
+	key, ok := keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	return key, nil
+}
+
+// algorithms lists signing methods matching the key types in the set, so a token
+// cannot pick an algorithm the key was not meant for
+func (keys jwks) algorithms() []string {
+	res := make([]string, 0)
+	seen := make(map[string]bool)
+
+	add := func(algs ...string) {
+		for _, alg := range algs {
+			if !seen[alg] {
+				seen[alg] = true
+				res = append(res, alg)
+			}
+		}
+	}
+
+	for _, key := range keys {
+		switch key.(type) {
+		case *rsa.PublicKey:
+			add("RS256", "RS384", "RS512", "PS256", "PS384", "PS512")
+		case *ecdsa.PublicKey:
+			add("ES256", "ES384", "ES512")
+		case ed25519.PublicKey:
+			add("EdDSA")
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,69 @@
+package ws
+
+import (
+	"net"
+	"net/http"
+)
+
+// Limits protect the server from greedy clients, zero values mean unlimited
+type Limits struct {
+	MaxConnections       int
+	MaxConnectionsPerIP  int
+	MaxConnectionsPerKey int
+	// ControlRate refills the inbound control message bucket per second, ControlBurst is its size
+	ControlRate      float64
+	ControlBurst     int
+	MaxSubscriptions int
+}
+
+// limitError is a violation reported to the client before it is disconnected
+type limitError struct {
+	code    string
+	message string
+}
+
+func (e *limitError) Error() string {
+	return e.message
+}
+
+var (
+	errTooManyConnections       = &limitError{code: "too_many_connections", message: "server connection limit reached"}
+	errTooManyConnectionsPerIP  = &limitError{code: "too_many_connections_per_ip", message: "connection limit for the address reached"}
+	errTooManyConnectionsPerKey = &limitError{code: "too_many_connections_per_key", message: "connection limit for the key reached"}
+	errRateLimited              = &limitError{code: "rate_limited", message: "too many control messages"}
+	errTooManySubscriptions     = &limitError{code: "too_many_subscriptions", message: "subscription limit reached"}
+)
+
+// checkConnectionLimits must be called with ws.mu held
+func (ws *WebsocketServer) checkConnectionLimits(p principal, ip string) error {
+	if ws.limits.MaxConnections > 0 && len(ws.clients) >= ws.limits.MaxConnections {
+		return errTooManyConnections
+	}
+
+	if ws.limits.MaxConnectionsPerIP > 0 && ws.connsByIP[ip] >= ws.limits.MaxConnectionsPerIP {
+		return errTooManyConnectionsPerIP
+	}
+
+	if ws.limits.MaxConnectionsPerKey > 0 && p.id != anonymousId && ws.connsByKey[p.id] >= ws.limits.MaxConnectionsPerKey {
+		return errTooManyConnectionsPerKey
+	}
+
+	return nil
+}
+
+func (ws *WebsocketServer) checkSubscriptions(count int) error {
+	if ws.limits.MaxSubscriptions > 0 && count > ws.limits.MaxSubscriptions {
+		return errTooManySubscriptions
+	}
+
+	return nil
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
@@ -0,0 +1,162 @@
+package ws
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	internalServices "github.com/aggregate-binance-depth/internal/services"
+)
+
+// maxLiquidityBands bounds the bps values of one liquidity query
+const maxLiquidityBands = 16
+
+var errInvalidBps = fmt.Errorf("bps must be up to %d positive numbers", maxLiquidityBands)
+
+type curveResponse struct {
+	Symbol    string                             `json:"symbol"`
+	Bids      []internalServices.CumulativeLevel `json:"bids"`
+	Asks      []internalServices.CumulativeLevel `json:"asks"`
+	UpdatedAt time.Time                          `json:"updatedAt"`
+	Bucket    float64                            `json:"bucket,omitempty"`
+}
+
+type liquidityResponse struct {
+	Symbol string                       `json:"symbol"`
+	Bands  []internalServices.Liquidity `json:"bands"`
+}
+
+func (ws *WebsocketServer) registerLiquidityHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("GET /depth/{symbol}/curve", ws.authenticated(ws.handleCurve))
+	mux.HandleFunc("GET /depth/{symbol}/cumulative", ws.authenticated(ws.handleCumulative))
+	mux.HandleFunc("GET /depth/{symbol}/fill", ws.authenticated(ws.handleFill))
+	mux.HandleFunc("GET /depth/{symbol}/liquidity", ws.authenticated(ws.handleLiquidity))
+}
+
+// handleCurve serves the cumulative depth of the book as /depth/{symbol} would return it
+func (ws *WebsocketServer) handleCurve(w http.ResponseWriter, r *http.Request) {
+	book, status, err := ws.requestedBook(r)
+	if err != nil {
+		ws.writeResponse(w, status, errorResponse{Error: err.Error()})
+
+		return
+	}
+
+	ws.writeResponse(w, http.StatusOK, curveResponse{
+		Symbol:    book.Symbol,
+		Bids:      internalServices.Cumulative(book.Bids),
+		Asks:      internalServices.Cumulative(book.Asks),
+		UpdatedAt: book.UpdatedAt,
+		Bucket:    book.Bucket,
+	})
+}
+
+// handleCumulative serves the quantity and notional from the top of book to the price query
+func (ws *WebsocketServer) handleCumulative(w http.ResponseWriter, r *http.Request) {
+	p, err := parsePositive(r, "price")
+	if err != nil {
+		ws.writeResponse(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+
+		return
+	}
+
+	res, ok := ws.depthGateService.CumulativeTo(r.PathValue("symbol"), p)
+	if !ok {
+		ws.writeResponse(w, http.StatusNotFound, errorResponse{Error: errSymbolNotFound.Error()})
+
+		return
+	}
+
+	ws.writeResponse(w, http.StatusOK, res)
+}
+
+// handleFill estimates a market order of the side query for the quantity or notional query
+func (ws *WebsocketServer) handleFill(w http.ResponseWriter, r *http.Request) {
+	var qty, notional float64
+	var err error
+
+	query := r.URL.Query()
+
+	switch {
+	case query.Has("quantity") == query.Has("notional"):
+		err = errors.New("one of quantity and notional is required")
+	case query.Has("quantity"):
+		qty, err = parsePositive(r, "quantity")
+	default:
+		notional, err = parsePositive(r, "notional")
+	}
+
+	if err != nil {
+		ws.writeResponse(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+
+		return
+	}
+
+	res, ok, err := ws.depthGateService.Fill(r.PathValue("symbol"), query.Get("side"), qty, notional)
+	if err != nil {
+		ws.writeResponse(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+
+		return
+	}
+
+	if !ok {
+		ws.writeResponse(w, http.StatusNotFound, errorResponse{Error: errSymbolNotFound.Error()})
+
+		return
+	}
+
+	ws.writeResponse(w, http.StatusOK, res)
+}
+
+// handleLiquidity serves what rests within each bps query of the mid price, e.g. ?bps=10&bps=50 or ?bps=10,50
+func (ws *WebsocketServer) handleLiquidity(w http.ResponseWriter, r *http.Request) {
+	bps, err := parseBps(r)
+	if err != nil {
+		ws.writeResponse(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+
+		return
+	}
+
+	bands, ok := ws.depthGateService.Liquidity(r.PathValue("symbol"), bps)
+	if !ok {
+		ws.writeResponse(w, http.StatusNotFound, errorResponse{Error: "symbol not found or one side of its book is empty"})
+
+		return
+	}
+
+	ws.writeResponse(w, http.StatusOK, liquidityResponse{Symbol: internalServices.NormalizeBookKey(r.PathValue("symbol")), Bands: bands})
+}
+
+func parsePositive(r *http.Request, name string) (float64, error) {
+	v, err := strconv.ParseFloat(r.URL.Query().Get(name), 64)
+	if err != nil || !(v > 0) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("%s must be a positive number", name)
+	}
+
+	return v, nil
+}
+
+func parseBps(r *http.Request) ([]float64, error) {
+	res := make([]float64, 0)
+
+	for _, raw := range r.URL.Query()["bps"] {
+		for _, part := range strings.Split(raw, ",") {
+			v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+			if err != nil || !(v > 0) || math.IsInf(v, 0) {
+				return nil, errInvalidBps
+			}
+
+			res = append(res, v)
+		}
+	}
+
+	if len(res) == 0 || len(res) > maxLiquidityBands {
+		return nil, errInvalidBps
+	}
+
+	return res, nil
+}
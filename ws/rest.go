@@ -0,0 +1,269 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/aggregate-binance-depth/infra"
+	"github.com/aggregate-binance-depth/internal/instruments"
+	"github.com/aggregate-binance-depth/internal/metrics"
+	internalServices "github.com/aggregate-binance-depth/internal/services"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	defaultBookLevels = 20
+	maxBookLevels     = 1000
+)
+
+var (
+	errInvalidLevels  = fmt.Errorf("levels must be an integer between 1 and %d", maxBookLevels)
+	errInvalidBucket  = fmt.Errorf("bucket must be a positive price increment")
+	errSymbolNotFound = errors.New("symbol not found")
+)
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// instrumentRegistry is the metadata of the tracked instruments served on /instruments
+type instrumentRegistry interface {
+	All() []instruments.Instrument
+	Get(symbol string) (instruments.Instrument, bool)
+}
+
+// RegisterInstruments serves the registry's metadata, without it /instruments is empty
+func (ws *WebsocketServer) RegisterInstruments(registry instrumentRegistry) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	ws.instruments = registry
+}
+
+func (ws *WebsocketServer) registerRestHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("GET /depth", ws.authenticated(ws.handleDepths))
+	mux.HandleFunc("GET /depth/{symbol}", ws.authenticated(ws.handleDepth))
+	ws.registerLiquidityHandlers(mux)
+	mux.HandleFunc("GET /bars/{symbol}", ws.authenticated(ws.handleBars))
+	mux.HandleFunc("GET /symbols", ws.authenticated(ws.handleSymbols))
+	mux.HandleFunc("GET /instruments", ws.authenticated(ws.handleInstruments))
+	mux.HandleFunc("GET /instruments/{symbol}", ws.authenticated(ws.handleInstrument))
+	mux.HandleFunc("GET /metrics/compression", ws.handleCompressionMetrics)
+	mux.Handle("GET /metrics", promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{}))
+}
+
+type principalKey struct{}
+
+// authenticated serves h to requests passing the origin and credential checks of the streams,
+// a {symbol} in the path must be one the principal may read
+func (ws *WebsocketServer) authenticated(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !ws.checkOrigin(r) {
+			ws.writeResponse(w, http.StatusForbidden, errorResponse{Error: "origin not allowed"})
+
+			return
+		}
+
+		p, err := ws.auth.authenticate(credential(r))
+		if err != nil {
+			ws.writeResponse(w, http.StatusUnauthorized, errorResponse{Error: "unauthorized"})
+
+			return
+		}
+
+		if s := r.PathValue("symbol"); s != "" && !p.allows(s) {
+			ws.writeResponse(w, http.StatusForbidden, errorResponse{Error: fmt.Sprintf("symbol %s is not allowed", s)})
+
+			return
+		}
+
+		h(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	}
+}
+
+// principalOf is the principal authenticated serves a request for
+func principalOf(r *http.Request) principal {
+	p, _ := r.Context().Value(principalKey{}).(principal)
+
+	return p
+}
+
+func (ws *WebsocketServer) handleDepths(w http.ResponseWriter, r *http.Request) {
+	levels, err := parseLevels(r)
+	if err != nil {
+		ws.writeResponse(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+
+		return
+	}
+
+	p := principalOf(r)
+	books := ws.depthGateService.Books(levels)
+	res := make([]internalServices.BookSnapshot, 0, len(books))
+
+	for _, book := range books {
+		if p.allows(book.Symbol) {
+			res = append(res, book)
+		}
+	}
+
+	ws.writeResponse(w, http.StatusOK, res)
+}
+
+func (ws *WebsocketServer) handleDepth(w http.ResponseWriter, r *http.Request) {
+	book, status, err := ws.requestedBook(r)
+	if err != nil {
+		ws.writeResponse(w, status, errorResponse{Error: err.Error()})
+
+		return
+	}
+
+	ws.writeResponse(w, http.StatusOK, book)
+}
+
+// requestedBook is the book of the path's symbol limited by the levels query and grouped
+// by the bucket query, the status goes with the error
+func (ws *WebsocketServer) requestedBook(r *http.Request) (internalServices.BookSnapshot, int, error) {
+	levels, err := parseLevels(r)
+	if err != nil {
+		return internalServices.BookSnapshot{}, http.StatusBadRequest, err
+	}
+
+	bucket, err := parseBucket(r)
+	if err != nil {
+		return internalServices.BookSnapshot{}, http.StatusBadRequest, err
+	}
+
+	var book internalServices.BookSnapshot
+	var ok bool
+
+	if bucket > 0 {
+		book, ok, err = ws.depthGateService.GroupedBook(r.PathValue("symbol"), bucket, levels)
+	} else {
+		book, ok = ws.depthGateService.Book(r.PathValue("symbol"), levels)
+	}
+
+	if err != nil {
+		return internalServices.BookSnapshot{}, http.StatusBadRequest, err
+	}
+
+	if !ok {
+		return internalServices.BookSnapshot{}, http.StatusNotFound, errSymbolNotFound
+	}
+
+	return book, http.StatusOK, nil
+}
+
+func (ws *WebsocketServer) handleSymbols(w http.ResponseWriter, r *http.Request) {
+	p := principalOf(r)
+	symbols := ws.depthGateService.Symbols()
+	res := make([]internalServices.SymbolStatus, 0, len(symbols))
+
+	for _, status := range symbols {
+		if p.allows(status.Symbol) {
+			res = append(res, status)
+		}
+	}
+
+	ws.writeResponse(w, http.StatusOK, res)
+}
+
+func (ws *WebsocketServer) handleInstruments(w http.ResponseWriter, r *http.Request) {
+	if ws.instruments == nil {
+		ws.writeResponse(w, http.StatusOK, []instruments.Instrument{})
+
+		return
+	}
+
+	p := principalOf(r)
+	all := ws.instruments.All()
+	res := make([]instruments.Instrument, 0, len(all))
+
+	for _, instrument := range all {
+		if p.allows(instrument.Symbol) {
+			res = append(res, instrument)
+		}
+	}
+
+	ws.writeResponse(w, http.StatusOK, res)
+}
+
+func (ws *WebsocketServer) handleInstrument(w http.ResponseWriter, r *http.Request) {
+	if ws.instruments == nil {
+		ws.writeResponse(w, http.StatusNotFound, errorResponse{Error: "instrument not found"})
+
+		return
+	}
+
+	instrument, ok := ws.instruments.Get(r.PathValue("symbol"))
+	if !ok {
+		ws.writeResponse(w, http.StatusNotFound, errorResponse{Error: "instrument not found"})
+
+		return
+	}
+
+	ws.writeResponse(w, http.StatusOK, instrument)
+}
+
+func (ws *WebsocketServer) handleCompressionMetrics(w http.ResponseWriter, r *http.Request) {
+	ws.mu.Lock()
+
+	res := make(map[string]infra.TrafficSnapshot, len(ws.trafficStats))
+
+	for name, stats := range ws.trafficStats {
+		res[name] = stats.Snapshot()
+	}
+
+	ws.mu.Unlock()
+
+	ws.writeResponse(w, http.StatusOK, res)
+}
+
+func (ws *WebsocketServer) writeResponse(w http.ResponseWriter, status int, body any) {
+	const op = "ws.rest.writeResponse"
+
+	logger := ws.log.With(slog.String("op", op))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		logger.Error("error with Encode", slog.String("error", err.Error()))
+	}
+}
+
+// parseLevels reads the optional levels query, defaults to defaultBookLevels
+func parseLevels(r *http.Request) (int, error) {
+	raw := r.URL.Query().Get("levels")
+
+	if raw == "" {
+		return defaultBookLevels, nil
+	}
+
+	levels, err := strconv.Atoi(raw)
+	if err != nil || levels <= 0 || levels > maxBookLevels {
+		return 0, errInvalidLevels
+	}
+
+	return levels, nil
+}
+
+// parseBucket reads the optional bucket query grouping the book by a price increment, zero when absent
+func parseBucket(r *http.Request) (float64, error) {
+	raw := r.URL.Query().Get("bucket")
+	if raw == "" {
+		return 0, nil
+	}
+
+	bucket, err := strconv.ParseFloat(raw, 64)
+	if err != nil || !(bucket > 0) || math.IsInf(bucket, 0) {
+		return 0, errInvalidBucket
+	}
+
+	return bucket, nil
+}
@@ -0,0 +1,149 @@
+package ws
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aggregate-binance-depth/internal/metrics"
+)
+
+const sseKeepAliveInterval = 15 * time.Second
+
+// HandleStream serves depth updates as Server-Sent Events.
+// A reconnecting client that sends Last-Event-ID equal to the latest event id
+// continues with live updates, any other client gets the current snapshot first.
+func (ws *WebsocketServer) HandleStream(w http.ResponseWriter, r *http.Request) {
+	const op = "services.ws.HandleStream"
+
+	logger := ws.log.With(slog.String("op", op))
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		logger.Error("streaming is not supported by response writer")
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+
+		return
+	}
+
+	p, symbols, ok := ws.authorize(logger, w, r)
+	if !ok {
+		return
+	}
+
+	channels, err := parseChannels(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	c, err := ws.addClient(nil, p, remoteIP(r), encodingJSON, symbols, channels, parseLastEventId(r))
+	if err != nil {
+		var limitErr *limitError
+
+		if errors.As(err, &limitErr) {
+			logger.Warn("client rejected", slog.String("principal", p.id), slog.String("code", limitErr.code))
+			ws.writeResponse(w, http.StatusTooManyRequests, errorResponseOf(limitErr.code, limitErr))
+
+			return
+		}
+
+		logger.Error("error with addClient", slog.String("error", err.Error()))
+		http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+
+		return
+	}
+
+	logger = logger.With(slog.Int("client", c.id), slog.String("principal", p.id))
+	logger.Debug("sse client connected")
+
+	defer func() {
+		ws.removeClient(c)
+		logger.Debug("sse client disconnected")
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-c.done:
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				logger.Debug("error with keep-alive", slog.String("error", err.Error()))
+
+				return
+			}
+
+			flusher.Flush()
+		case m := <-c.send:
+			span := ws.startSend(c, m)
+			_, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", m.id, m.event, m.data)
+			span.End()
+
+			if err != nil {
+				logger.Debug("error with write event", slog.String("error", err.Error()))
+
+				return
+			}
+
+			metrics.SentBytes.WithLabelValues(c.transport()).Add(float64(len(m.data)))
+
+			flusher.Flush()
+		}
+	}
+}
+
+// parseSymbols reads a comma separated symbols query, an empty result means all symbols
+func parseSymbols(r *http.Request) []string {
+	raw := r.URL.Query().Get("symbols")
+
+	if raw == "" {
+		return nil
+	}
+
+	res := make([]string, 0)
+
+	for _, s := range strings.Split(raw, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			res = append(res, s)
+		}
+	}
+
+	return res
+}
+
+// parseLastEventId takes the id from the Last-Event-ID header or lastEventId query for
+// EventSource polyfills that cannot set headers
+func parseLastEventId(r *http.Request) *uint64 {
+	raw := r.Header.Get("Last-Event-ID")
+
+	if raw == "" {
+		raw = r.URL.Query().Get("lastEventId")
+	}
+
+	if raw == "" {
+		return nil
+	}
+
+	lastEventId, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		return nil
+	}
+
+	return &lastEventId
+}
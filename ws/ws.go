@@ -2,67 +2,220 @@ package ws
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
+	"slices"
 	"sync"
+	"time"
 
+	"github.com/aggregate-binance-depth/infra"
+	"github.com/aggregate-binance-depth/internal/metrics"
 	internalServices "github.com/aggregate-binance-depth/internal/services"
+	"github.com/aggregate-binance-depth/internal/tracing"
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
 )
 
 type id = int
 
-type client struct {
-	id   id
-	conn *websocket.Conn
-}
+var tracer = tracing.Tracer("github.com/aggregate-binance-depth/ws")
+
+const certWatchInterval = 10 * time.Second
 
 type WebsocketServer struct {
 	log              *slog.Logger
 	upgrader         websocket.Upgrader
-	clients          map[id]client
+	auth             authenticator
+	checkOrigin      func(r *http.Request) bool
+	clients          map[id]*client
+	connsByIP        map[string]int
+	connsByKey       map[string]int
+	limits           Limits
 	depthGateService depthGateService
+	instruments      instrumentRegistry
+	bars             barHistory
+	shards           []upstreamShard
 	mu               sync.Mutex
 	server           *http.Server
-	closed           bool
+	address          string
+	certReloader     *infra.CertReloader
+	// certWatch lives from NewWebsocketServer until Shutdown cancels it with stopCertWatch
+	certWatch     context.Context
+	stopCertWatch context.CancelFunc
+	closed        bool
+	// seq numbers broadcast events, SSE clients see it as the event id
+	seq         uint64
+	compression infra.Compression
+	// traffic counts websocket client bytes, trafficStats is what /metrics/compression reports
+	traffic      *infra.TrafficStats
+	trafficStats map[string]*infra.TrafficStats
 	// TODO: take id from connection
 	maxId int
 }
 
 type depthGateService interface {
-	WriteCurrentDeps() error
+	CurrentDepths() []internalServices.DepthWriterRequest
+	Books(levels int) []internalServices.BookSnapshot
+	Book(symbol string, levels int) (internalServices.BookSnapshot, bool)
+	GroupedBook(symbol string, bucket float64, levels int) (internalServices.BookSnapshot, bool, error)
+	CumulativeTo(symbol string, price float64) (internalServices.CumulativeDepth, bool)
+	Fill(symbol string, side string, quantity float64, notional float64) (internalServices.FillEstimate, bool, error)
+	Liquidity(symbol string, bps []float64) ([]internalServices.Liquidity, bool)
+	Symbols() []internalServices.SymbolStatus
 }
 
-func (ws *WebsocketServer) WriteJSON(target internalServices.DepthWriterRequest) error {
+func (ws *WebsocketServer) WriteJSON(ctx context.Context, target internalServices.DepthWriterRequest) error {
 	const op = "services.ws.WriteJSON"
 
 	logger := ws.log.With(slog.String("op", op))
 
-	for _, client := range ws.clients {
-		if err := client.conn.WriteJSON(target); err != nil {
-			logger.Error("error with WriteJSON", slog.String("error", err.Error()))
-		}
+	if err := ws.broadcast(ctx, logger, eventDepth, target.Symbol, target); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
 	}
 
 	return nil
 }
 
-func (ws *WebsocketServer) BulkWriteJSON(target []internalServices.DepthWriterRequest) error {
+func (ws *WebsocketServer) BulkWriteJSON(ctx context.Context, target []internalServices.DepthWriterRequest) error {
 	const op = "services.ws.BulkWriteJSON"
 
 	logger := ws.log.With(slog.String("op", op))
 
-	for _, client := range ws.clients {
-		if err := client.conn.WriteJSON(target); err != nil {
-			logger.Error("error with WriteJSON", slog.String("error", err.Error()))
-			// TODO: collect error
-		}
+	if err := ws.broadcast(ctx, logger, eventSnapshot, "", target); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
 	}
 
 	return nil
 }
 
+// broadcast queues payload for every client subscribed to the symbol, the payload
+// is encoded and framed at most once per encoding and shared between clients
+func (ws *WebsocketServer) broadcast(ctx context.Context, logger *slog.Logger, event string, symbol string, payload any) error {
+	_, span := tracer.Start(ctx, "ws.broadcast", trace.WithAttributes(attribute.String("event", event)))
+	defer span.End()
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	ws.seq++
+
+	queued := 0
+
+	shared := make(frames, len(encodings))
+	errs := make(map[encoding]error)
+
+	for _, c := range ws.clients {
+		if !c.wants(symbol) || !c.listens(event) {
+			continue
+		}
+
+		f, err := shared.forClient(c, payload)
+		if err != nil {
+			if _, seen := errs[c.encoding]; !seen {
+				logger.Error("error with encode", slog.String("encoding", string(c.encoding)), slog.String("error", err.Error()))
+				errs[c.encoding] = err
+			}
+
+			continue
+		}
+
+		m := message{id: ws.seq, event: event, symbol: symbol, data: f.data, prepared: f.prepared, trace: span.SpanContext()}
+
+		if !c.enqueue(m) {
+			metrics.DroppedMessages.WithLabelValues(c.transport()).Inc()
+			logger.Warn("client queue is full, message dropped", slog.Int("client", c.id))
+
+			continue
+		}
+
+		queued++
+	}
+
+	span.SetAttributes(attribute.Int("clients", queued))
+
+	joined := make([]error, 0, len(errs))
+
+	for _, err := range errs {
+		joined = append(joined, err)
+	}
+
+	return errors.Join(joined...)
+}
+
+// addClient registers a client and queues the current snapshot for it unless
+// lastEventId shows the client has not missed anything, a limitError rejects the client
+func (ws *WebsocketServer) addClient(conn *websocket.Conn, p principal, ip string, enc encoding, symbols []string, channels []string, lastEventId *uint64) (*client, error) {
+	const op = "services.ws.addClient"
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.closed {
+		return nil, fmt.Errorf("%s: %s", op, "server already closed")
+	}
+
+	if err := ws.checkConnectionLimits(p, ip); err != nil {
+		return nil, err
+	}
+
+	if err := ws.checkSubscriptions(len(symbols)); err != nil {
+		return nil, err
+	}
+
+	c := newClient(ws.maxId, conn, p, ip, enc, symbols, channels)
+	ws.maxId++
+
+	if ws.limits.ControlRate > 0 {
+		c.limiter = rate.NewLimiter(rate.Limit(ws.limits.ControlRate), max(ws.limits.ControlBurst, 1))
+	}
+
+	if c.listens(eventSnapshot) && (lastEventId == nil || *lastEventId != ws.seq) {
+		if err := ws.enqueueSnapshot(c, c.wants); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	ws.clients[c.id] = c
+	ws.connsByIP[c.ip]++
+	ws.connsByKey[c.principal.id]++
+
+	metrics.ConnectedClients.WithLabelValues(c.transport()).Inc()
+
+	return c, nil
+}
+
+func (ws *WebsocketServer) removeClient(c *client) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if _, ok := ws.clients[c.id]; ok {
+		delete(ws.clients, c.id)
+		decrement(ws.connsByIP, c.ip)
+		decrement(ws.connsByKey, c.principal.id)
+
+		metrics.ConnectedClients.WithLabelValues(c.transport()).Dec()
+	}
+
+	c.close()
+}
+
+func decrement(counts map[string]int, key string) {
+	if counts[key] <= 1 {
+		delete(counts, key)
+
+		return
+	}
+
+	counts[key]--
+}
+
 func (ws *WebsocketServer) RegisterDepthGateService(dgs depthGateService) error {
 	const op = "services.ws.RegisterDepthGateService"
 
@@ -82,55 +235,242 @@ func (ws *WebsocketServer) RegisterDepthGateService(dgs depthGateService) error
 	return nil
 }
 
-func NewWebsocketServer(l *slog.Logger) *WebsocketServer {
+type Options struct {
+	// Address is the listen address, e.g. "0.0.0.0:8080"
+	Address     string
+	Compression infra.Compression
+	Auth        AuthOptions
+	// TLS is optional, certificate files are watched and reloaded on change
+	TLS    *infra.TLSFiles
+	Limits Limits
+}
+
+func NewWebsocketServer(l *slog.Logger, opts Options) (*WebsocketServer, error) {
+	const op = "services.ws.NewWebsocketServer"
+
+	auth, err := newAuthenticator(opts.Auth)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var certReloader *infra.CertReloader
+
+	if opts.TLS != nil {
+		if certReloader, err = infra.NewCertReloader(l, *opts.TLS); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	traffic := &infra.TrafficStats{}
+	certWatch, stopCertWatch := context.WithCancel(context.Background())
+
 	return &WebsocketServer{
-		clients: make(map[id]client),
-		log:     l,
+		clients:       make(map[id]*client),
+		connsByIP:     make(map[string]int),
+		connsByKey:    make(map[string]int),
+		limits:        opts.Limits,
+		log:           l,
+		address:       opts.Address,
+		certReloader:  certReloader,
+		certWatch:     certWatch,
+		stopCertWatch: stopCertWatch,
+		auth:          auth,
+		checkOrigin:   checkOrigin(opts.Auth.AllowedOrigins),
+		compression:   opts.Compression,
+		traffic:       traffic,
+		trafficStats:  map[string]*infra.TrafficStats{"downstream": traffic},
 		upgrader: websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool {
-				return true
-			},
+			Subprotocols:      subprotocols(),
+			EnableCompression: opts.Compression.Enabled,
+			CheckOrigin:       checkOrigin(opts.Auth.AllowedOrigins),
 		},
+	}, nil
+}
+
+// authorize checks origin and credentials and narrows requested symbols to the allowed ones,
+// on failure the response is already written
+func (ws *WebsocketServer) authorize(logger *slog.Logger, w http.ResponseWriter, r *http.Request) (principal, []string, bool) {
+	if !ws.checkOrigin(r) {
+		logger.Warn("origin not allowed", slog.String("origin", r.Header.Get("Origin")))
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+
+		return principal{}, nil, false
 	}
+
+	p, err := ws.auth.authenticate(credential(r))
+	if err != nil {
+		logger.Warn("authentication failed", slog.String("error", err.Error()))
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+
+		return principal{}, nil, false
+	}
+
+	symbols, err := p.restrict(parseSymbols(r))
+	if err != nil {
+		logger.Warn("symbols not allowed", slog.String("client", p.id), slog.String("error", err.Error()))
+		http.Error(w, err.Error(), http.StatusForbidden)
+
+		return principal{}, nil, false
+	}
+
+	return p, symbols, true
 }
 
-func (ws *WebsocketServer) handleClient(conn *websocket.Conn) {
+// RegisterTrafficStats adds stats of another connection, e.g. upstream, to /metrics/compression
+func (ws *WebsocketServer) RegisterTrafficStats(name string, stats *infra.TrafficStats) error {
+	const op = "services.ws.RegisterTrafficStats"
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if _, ok := ws.trafficStats[name]; ok {
+		return fmt.Errorf("%s: traffic stats %q already set", op, name)
+	}
+
+	ws.trafficStats[name] = stats
+
+	return nil
+}
+
+func (ws *WebsocketServer) handleClient(conn *websocket.Conn, p principal, ip string, enc encoding, symbols []string, channels []string) {
 	const op = "services.websocket.handleClient"
 
 	logger := ws.log.With(slog.String("op", op))
 
-	var clientId int
+	c, err := ws.addClient(conn, p, ip, enc, symbols, channels, nil)
+	if err != nil {
+		var limitErr *limitError
+
+		if errors.As(err, &limitErr) {
+			logger.Warn("client rejected", slog.String("ip", ip), slog.String("principal", p.id), slog.String("code", limitErr.code))
+			rejectConn(conn, limitErr)
 
-	func() {
-		ws.mu.Lock()
-		defer ws.mu.Unlock()
+			return
+		}
 
-		clientId := ws.maxId
+		logger.Error("error with addClient", slog.String("error", err.Error()))
 
-		ws.clients[clientId] = client{id: clientId, conn: conn}
-		ws.maxId++
-		logger.Debug("client connected")
-	}()
+		return
+	}
+
+	logger = logger.With(slog.Int("client", c.id), slog.String("principal", p.id))
+	logger.Debug("client connected")
+
+	netConn := conn.NetConn()
+
+	if tlsConn, ok := netConn.(*tls.Conn); ok {
+		netConn = tlsConn.NetConn()
+	}
+
+	if cc, ok := netConn.(*infra.CountingConn); ok {
+		cc.Track(ws.traffic)
+	}
+
+	if ws.compression.Enabled {
+		if err := conn.SetCompressionLevel(ws.compression.Level); err != nil {
+			logger.Error("error with SetCompressionLevel", slog.String("error", err.Error()))
+		}
+	}
 
 	defer func() {
-		ws.mu.Lock()
-		defer ws.mu.Unlock()
-		delete(ws.clients, clientId)
+		ws.removeClient(c)
 		logger.Debug("client disconnected")
 	}()
 
-	ws.depthGateService.WriteCurrentDeps()
+	go ws.writePump(logger, c)
+
+	// after a violation the loop only drains the connection until writePump closes it
+	rejected := false
 
 	for !ws.closed {
-		messageType, message, err := conn.ReadMessage()
+		messageType, data, err := conn.ReadMessage()
 		if err != nil {
 			logger.Error("error recive message", slog.String("error", err.Error()))
 
 			break
 		}
 
-		logger.Debug("received", slog.Any("message", message), slog.Int("messageType", messageType))
+		logger.Debug("received", slog.Any("message", data), slog.Int("messageType", messageType))
+
+		if rejected {
+			continue
+		}
+
+		if c.limiter != nil && !c.limiter.Allow() {
+			rejected = ws.reject(logger, c, errRateLimited)
+
+			continue
+		}
+
+		res, err := ws.handleControl(c, data)
+		if err != nil {
+			var limitErr *limitError
+
+			if errors.As(err, &limitErr) {
+				rejected = ws.reject(logger, c, limitErr)
+			}
+
+			continue
+		}
+
+		ws.reply(logger, c, res, 0)
+	}
+}
+
+// writePump is the only writer of the client connection
+func (ws *WebsocketServer) writePump(logger *slog.Logger, c *client) {
+	for {
+		select {
+		case <-c.done:
+			return
+		case m := <-c.send:
+			span := ws.startSend(c, m)
+
+			var err error
+
+			switch {
+			case m.control:
+				err = c.conn.WriteMessage(websocket.TextMessage, m.data)
+			case m.prepared != nil:
+				err = c.conn.WritePreparedMessage(m.prepared)
+			default:
+				err = c.conn.WriteMessage(c.encoding.messageType(), m.data)
+			}
+
+			span.End()
+
+			if err != nil {
+				logger.Error("error with write message", slog.String("error", err.Error()))
+				c.conn.Close()
+
+				return
+			}
+
+			ws.traffic.AddRawWritten(len(m.data))
+			metrics.SentBytes.WithLabelValues(c.transport()).Add(float64(len(m.data)))
+
+			if m.closeCode != 0 {
+				closeConn(c.conn, m.closeCode, "")
+
+				return
+			}
+		}
+	}
+}
+
+// startSend starts the span of a write to a client, messages without a sampled
+// trace (snapshots and control replies) get a no-op span
+func (ws *WebsocketServer) startSend(c *client, m message) trace.Span {
+	if !m.trace.IsSampled() {
+		return trace.SpanFromContext(context.Background())
 	}
+
+	_, span := tracer.Start(tracing.ContextWith(m.trace), "ws.send", trace.WithAttributes(
+		attribute.Int("client", c.id),
+		attribute.String("encoding", string(c.encoding)),
+	))
+
+	return span
 }
 
 func (ws *WebsocketServer) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
@@ -138,6 +478,18 @@ func (ws *WebsocketServer) HandleWebSocket(w http.ResponseWriter, r *http.Reques
 
 	logger := ws.log.With(slog.String("op", op))
 
+	p, symbols, ok := ws.authorize(logger, w, r)
+	if !ok {
+		return
+	}
+
+	channels, err := parseChannels(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
 	conn, err := ws.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		logger.Error("upgrade error", slog.String("error", err.Error()))
@@ -145,12 +497,60 @@ func (ws *WebsocketServer) HandleWebSocket(w http.ResponseWriter, r *http.Reques
 	}
 	defer conn.Close()
 
-	ws.handleClient(conn)
+	enc, err := negotiateEncoding(conn, r)
+	if err != nil {
+		logger.Error("error with negotiateEncoding", slog.String("error", err.Error()))
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseUnsupportedData, err.Error()))
+
+		return
+	}
+
+	if enc == encodingBinary && slices.Contains(channels, channelBars) {
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseUnsupportedData, errBinaryBars.Error()))
+
+		return
+	}
+
+	ws.handleClient(conn, p, remoteIP(r), enc, symbols, channels)
 
 	return
 }
 
-func (ws *WebsocketServer) Serve(port int) {
+// reply queues a control response, closeCode disconnects the client once it is written
+func (ws *WebsocketServer) reply(logger *slog.Logger, c *client, res controlResponse, closeCode int) bool {
+	data, err := json.Marshal(res)
+	if err != nil {
+		logger.Error("error with Marshal", slog.String("error", err.Error()))
+
+		return false
+	}
+
+	return c.enqueue(message{data: data, control: true, closeCode: closeCode})
+}
+
+// reject reports a violation and lets writePump disconnect the client, a full queue drops the connection at once
+func (ws *WebsocketServer) reject(logger *slog.Logger, c *client, limitErr *limitError) bool {
+	logger.Warn("client violated limits", slog.String("code", limitErr.code))
+
+	if !ws.reply(logger, c, errorResponseOf(limitErr.code, limitErr), websocket.ClosePolicyViolation) {
+		c.conn.Close()
+	}
+
+	return true
+}
+
+// rejectConn answers a connection that never got a writePump
+func rejectConn(conn *websocket.Conn, limitErr *limitError) {
+	conn.WriteJSON(errorResponseOf(limitErr.code, limitErr))
+	closeConn(conn, websocket.ClosePolicyViolation, limitErr.code)
+}
+
+func closeConn(conn *websocket.Conn, code int, reason string) {
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+	conn.Close()
+}
+
+func (ws *WebsocketServer) Serve() {
 	const op = "services.ws.Serve"
 
 	logger := ws.log.With(slog.String("op", op))
@@ -161,42 +561,73 @@ func (ws *WebsocketServer) Serve(port int) {
 		return
 	}
 
-	http.HandleFunc("/ws", ws.HandleWebSocket)
+	logger.Info("starting WebSocket server on ", slog.String("address", ws.address), slog.Bool("tls", ws.certReloader != nil))
 
-	logger.Info("starting WebSocket server on ", slog.Int("port", port))
+	ws.server = &http.Server{
+		Addr:    ws.address,
+		Handler: ws.newMux(),
+	}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/ws", ws.HandleWebSocket)
+	lis, err := net.Listen("tcp", ws.server.Addr)
+	if err != nil {
+		logger.Error("error with Listen", slog.String("error", err.Error()))
 
-	ws.server = &http.Server{
-		Addr:    fmt.Sprintf("localhost:%d", port),
-		Handler: mux,
+		return
 	}
 
-	if err := ws.server.ListenAndServe(); err != nil {
+	lis = infra.CountingListener{Listener: lis}
+
+	if ws.certReloader != nil {
+		go ws.certReloader.Watch(ws.certWatch, certWatchInterval)
+
+		lis = tls.NewListener(lis, ws.certReloader.TLSConfig())
+	}
+
+	if err := ws.server.Serve(lis); err != nil {
 		logger.Error("ws server stoped successfully")
 	}
 }
 
+// closeClients disconnects every client and refuses new ones
+func (ws *WebsocketServer) closeClients() {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	ws.closed = true
+	for _, client := range ws.clients {
+		client.close()
+
+		if client.conn != nil {
+			client.conn.Close()
+		}
+	}
+}
+
+// newMux routes the websocket, SSE, REST and health endpoints
+func (ws *WebsocketServer) newMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", ws.HandleWebSocket)
+	mux.HandleFunc("GET /stream", ws.HandleStream)
+	ws.registerRestHandlers(mux)
+	ws.registerHealthHandlers(mux)
+
+	return mux
+}
+
 func (ws *WebsocketServer) Shutdown(ctx context.Context) {
 	const op = "services.ws.Serve"
 
 	logger := ws.log.With(slog.String("op", op))
 	logger.Debug("Shutting down server...")
 
+	// clients go first, open SSE responses would otherwise hold server.Shutdown until ctx expires
+	ws.closeClients()
+
 	if err := ws.server.Shutdown(ctx); err != nil {
 		logger.Error("Error during server shutdown", slog.String("error", err.Error()))
 	}
 
-	func() {
-		ws.mu.Lock()
-		defer ws.mu.Unlock()
-
-		ws.closed = true
-		for _, client := range ws.clients {
-			client.conn.Close()
-		}
-	}()
+	ws.stopCertWatch()
 
 	logger.Info("Server stopped")
 }